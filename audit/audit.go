@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+)
+
+// Stderr as the path writes the audit log to stderr instead of a file
+const Stderr = "stderr"
+
+const (
+	KindDecision = "decision"
+	KindRender   = "render"
+)
+
+// Render identifies a render in the audit log, all its records carry it
+type Render struct {
+	ID          string `json:"render"`
+	App         string `json:"app"`
+	Project     string `json:"project"`
+	Revision    string `json:"revision"`
+	Destination string `json:"destination"`
+}
+
+// Record is a line of the audit log
+type Record struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	Render
+	*k8s.Decision `json:",omitempty"`
+	// Result and Emitted are only set on KindRender records, which close the render
+	Result  string `json:"result,omitempty"`
+	Emitted *int   `json:"emitted,omitempty"`
+}
+
+// Log appends JSON lines, one per record.
+// Each record is a single write, so renders running at the same time do not mix up lines.
+type Log struct {
+	mu     sync.Mutex
+	writer io.Writer
+	closer io.Closer
+	now    func() time.Time
+}
+
+// Open returns nil if path is empty
+func Open(path string) (*Log, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case Stderr:
+		return &Log{writer: os.Stderr, now: time.Now}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{writer: f, closer: f, now: time.Now}, nil
+}
+
+// NewRender returns a render with a random ID
+func NewRender(app, project, revision, destination string) Render {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return Render{
+		ID:          hex.EncodeToString(id),
+		App:         app,
+		Project:     project,
+		Revision:    revision,
+		Destination: destination,
+	}
+}
+
+// Decision records what happened to a candidate secret
+func (l *Log) Decision(render Render, decision k8s.Decision) error {
+	return l.write(Record{
+		Kind:     KindDecision,
+		Render:   render,
+		Decision: &decision,
+	})
+}
+
+// Finish records the result of the render
+func (l *Log) Finish(render Render, result string, emitted int) error {
+	return l.write(Record{
+		Kind:    KindRender,
+		Render:  render,
+		Result:  result,
+		Emitted: &emitted,
+	})
+}
+
+func (l *Log) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+func (l *Log) write(record Record) error {
+	record.Time = l.now().UTC()
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.writer.Write(line); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
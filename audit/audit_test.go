@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	log, err := Open("")
+	require.NoError(t, err)
+	require.Nil(t, log)
+
+	write := func() Render {
+		log, err := Open(path)
+		require.NoError(t, err)
+		log.now = func() time.Time {
+			return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		}
+		defer log.Close()
+
+		render := NewRender("my-app", "default", "abc123", "my-test-namespace")
+		require.NoError(t, log.Decision(render, k8s.Decision{
+			Namespace: "my-test-namespace",
+			Name:      "labeled-secret",
+			Decision:  k8s.DecisionReplicated,
+			Matcher:   k8s.MatcherNamespace,
+			Digest:    "sha256:00",
+		}))
+		require.NoError(t, log.Decision(render, k8s.Decision{
+			Namespace: "some-other-namespace",
+			Name:      "other-secret",
+			Decision:  k8s.DecisionSkipped,
+			Reason:    k8s.ReasonNotAllowed,
+			Digest:    "sha256:01",
+		}))
+		require.NoError(t, log.Finish(render, "success", 1))
+		return render
+	}
+	first := write()
+	second := write()
+	require.NotEqual(t, first.ID, second.ID)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	records := []map[string]interface{}{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		record := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 6, "log must be appended to")
+
+	require.Equal(t, map[string]interface{}{
+		"time":        "2024-03-01T12:00:00Z",
+		"kind":        KindDecision,
+		"render":      first.ID,
+		"app":         "my-app",
+		"project":     "default",
+		"revision":    "abc123",
+		"destination": "my-test-namespace",
+		"namespace":   "my-test-namespace",
+		"name":        "labeled-secret",
+		"decision":    k8s.DecisionReplicated,
+		"matcher":     k8s.MatcherNamespace,
+		"digest":      "sha256:00",
+	}, records[0])
+	require.Equal(t, k8s.ReasonNotAllowed, records[1]["reason"])
+	require.Equal(t, map[string]interface{}{
+		"time":        "2024-03-01T12:00:00Z",
+		"kind":        KindRender,
+		"render":      first.ID,
+		"app":         "my-app",
+		"project":     "default",
+		"revision":    "abc123",
+		"destination": "my-test-namespace",
+		"result":      "success",
+		"emitted":     1.0,
+	}, records[2])
+	require.Equal(t, second.ID, records[3]["render"])
+}
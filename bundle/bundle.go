@@ -0,0 +1,148 @@
+package bundle
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"filippo.io/age"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Version of the bundle format
+const Version = 1
+
+var ErrUnsigned = errors.New("bundle is not signed")
+var ErrUntrusted = errors.New("bundle signature does not match any trusted key")
+
+// Bundle carries secrets allowed for one target namespace to a disconnected site.
+// Payload is age-encrypted list of secrets, signature covers everything else in the bundle.
+type Bundle struct {
+	Version   int    `json:"version"`
+	Target    string `json:"target"`
+	CreatedAt string `json:"createdAt"`
+	Payload   []byte `json:"payload"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// signedPayload is the message that gets signed, struct fields are always marshalled in the same order.
+func (b Bundle) signedPayload() []byte {
+	b.Signature = nil
+	payload, err := json.Marshal(b)
+	if err != nil {
+		panic(err)
+	}
+	return payload
+}
+
+// Seal encrypts secrets to recipients and signs the bundle with the key.
+// Only fields that matter for replication are kept, so the bundle does not leak server-side state.
+func Seal(secrets []corev1.Secret, target string, recipients []age.Recipient, key ed25519.PrivateKey) (*Bundle, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients to encrypt the bundle to")
+	}
+
+	list := corev1.SecretList{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "SecretList",
+		},
+		Items: []corev1.Secret{},
+	}
+	for _, secret := range secrets {
+		list.Items = append(list.Items, corev1.Secret{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: "v1",
+				Kind:       "Secret",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              secret.Name,
+				Namespace:         secret.Namespace,
+				Labels:            secret.Labels,
+				Annotations:       secret.Annotations,
+				CreationTimestamp: secret.CreationTimestamp,
+				ManagedFields:     secret.ManagedFields,
+			},
+			Type: secret.Type,
+			Data: secret.Data,
+		})
+	}
+	plaintext, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := &bytes.Buffer{}
+	w, err := age.Encrypt(ciphertext, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{
+		Version:   Version,
+		Target:    target,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Payload:   ciphertext.Bytes(),
+	}
+	b.Signature = ed25519.Sign(key, b.signedPayload())
+	return b, nil
+}
+
+// Read parses the bundle, it must be verified before it is opened.
+func Read(r io.Reader) (*Bundle, error) {
+	b := &Bundle{}
+	if err := json.NewDecoder(r).Decode(b); err != nil {
+		return nil, err
+	}
+	if b.Version != Version {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	return b, nil
+}
+
+func (b *Bundle) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(b)
+}
+
+// Verify checks the bundle was signed by one of the keys
+func (b *Bundle) Verify(keys []ed25519.PublicKey) error {
+	if len(b.Signature) == 0 {
+		return ErrUnsigned
+	}
+
+	payload := b.signedPayload()
+	for _, key := range keys {
+		if ed25519.Verify(key, payload, b.Signature) {
+			return nil
+		}
+	}
+
+	return ErrUntrusted
+}
+
+// Open decrypts secrets in the bundle
+func (b *Bundle) Open(identities []age.Identity) ([]corev1.Secret, error) {
+	plaintext, err := age.Decrypt(bytes.NewReader(b.Payload), identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt bundle: %w", err)
+	}
+
+	list := corev1.SecretList{}
+	if err := json.NewDecoder(plaintext).Decode(&list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
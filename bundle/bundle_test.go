@@ -0,0 +1,96 @@
+package bundle
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBundle(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	otherIdentity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	secrets := []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "labeled-secret",
+				Namespace:       "some-other-namespace",
+				UID:             "some-uid",
+				ResourceVersion: "42",
+				Labels: map[string]string{
+					types.ReplicatorLabel: "true",
+				},
+				Annotations: map[string]string{
+					types.ReplicatorAnnotationAllowedNamespaces: "my-test-namespace",
+				},
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				"key": []byte("value"),
+			},
+		},
+	}
+
+	sealed, err := Seal(secrets, "my-test-namespace", []age.Recipient{identity.Recipient()}, private)
+	require.NoError(t, err)
+	require.NotContains(t, string(sealed.Payload), "value")
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, sealed.Write(buf))
+	read, err := Read(buf)
+	require.NoError(t, err)
+
+	t.Run("open", func(t *testing.T) {
+		require.NoError(t, read.Verify([]ed25519.PublicKey{otherPublic, public}))
+		require.Equal(t, "my-test-namespace", read.Target)
+
+		opened, err := read.Open([]age.Identity{identity})
+		require.NoError(t, err)
+		require.Len(t, opened, 1)
+		require.Equal(t, "labeled-secret", opened[0].Name)
+		require.Equal(t, "some-other-namespace", opened[0].Namespace)
+		require.Equal(t, "my-test-namespace", opened[0].Annotations[types.ReplicatorAnnotationAllowedNamespaces])
+		require.Equal(t, "value", string(opened[0].Data["key"]))
+		require.Empty(t, opened[0].UID)
+		require.Empty(t, opened[0].ResourceVersion)
+	})
+	t.Run("untrusted", func(t *testing.T) {
+		require.ErrorIs(t, read.Verify([]ed25519.PublicKey{otherPublic}), ErrUntrusted)
+	})
+	t.Run("unsigned", func(t *testing.T) {
+		unsigned := *read
+		unsigned.Signature = nil
+		require.ErrorIs(t, unsigned.Verify([]ed25519.PublicKey{public}), ErrUnsigned)
+	})
+	t.Run("retargeted", func(t *testing.T) {
+		retargeted := *read
+		retargeted.Target = "some-other-namespace"
+		require.ErrorIs(t, retargeted.Verify([]ed25519.PublicKey{public}), ErrUntrusted)
+	})
+	t.Run("wrong-identity", func(t *testing.T) {
+		_, err := read.Open([]age.Identity{otherIdentity})
+		require.Error(t, err)
+	})
+	t.Run("no-recipients", func(t *testing.T) {
+		_, err := Seal(secrets, "my-test-namespace", nil, private)
+		require.Error(t, err)
+	})
+	t.Run("unsupported-version", func(t *testing.T) {
+		_, err := Read(bytes.NewBufferString(`{"version": 2}`))
+		require.ErrorContains(t, err, "unsupported bundle version")
+	})
+}
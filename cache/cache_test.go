@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/plumber-cd/argocd-cmp-replicator/metrics"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func newSecret(namespace, name string, labels, annotations map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Data: map[string][]byte{
+			"key": []byte("value"),
+		},
+	}
+}
+
+func TestCache(t *testing.T) {
+	clientset := testClient.NewSimpleClientset(
+		newSecret("my-test-namespace", "labeled-secret", map[string]string{
+			types.ReplicatorLabel: "true",
+		}, map[string]string{
+			types.ReplicatorAnnotationFromCluster: "forged",
+		}),
+		newSecret("some-other-namespace", "labeled-secret-for-any-namespace", map[string]string{
+			types.ReplicatorLabel: "true",
+		}, map[string]string{
+			types.ReplicatorAnnotationAllowedNamespaces: "*",
+		}),
+		newSecret("some-other-namespace", "alternative-secret", map[string]string{
+			types.ReplicatorLabelAlternative: "true",
+			"team":                           "platform",
+		}, map[string]string{
+			types.ReplicatorAnnotationAllowedNamespaces: "*",
+		}),
+		newSecret("my-test-namespace", "not-labeled", nil, nil),
+	)
+	direct := &k8s.Client{Interface: clientset}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	server := NewServer(clientset, time.Minute)
+	server.Metrics = metrics.New()
+	require.NoError(t, server.Start(ctx))
+
+	socket := filepath.Join(t.TempDir(), "cache.sock")
+	served := make(chan error)
+	go func() {
+		served <- server.Serve(ctx, socket)
+	}()
+	require.Eventually(t, func() bool { return Available(socket) }, 5*time.Second, 10*time.Millisecond)
+
+	client := NewClient(socket, direct)
+
+	t.Run("list-candidates", func(t *testing.T) {
+		secrets, err := client.ListCandidates(context.TODO(), types.ReplicatorLabel+"=true")
+		require.NoError(t, err)
+		require.Len(t, secrets, 2)
+		for _, secret := range secrets {
+			require.NotContains(t, secret.Annotations, types.ReplicatorAnnotationFromCluster)
+		}
+
+		secrets, err = client.ListCandidates(context.TODO(), types.ReplicatorLabelAlternative+"=true,team=platform")
+		require.NoError(t, err)
+		require.Len(t, secrets, 1)
+		require.Equal(t, "alternative-secret", secrets[0].Name)
+	})
+	t.Run("render", func(t *testing.T) {
+		fromCache, err := (&k8s.Client{Sources: []k8s.SecretSource{client}}).GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		fromAPI, err := direct.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		keys := func(secrets *corev1.SecretList) []string {
+			keys := []string{}
+			for _, secret := range secrets.Items {
+				keys = append(keys, secret.Namespace+"/"+secret.Name+"="+string(secret.Data["key"]))
+			}
+			return keys
+		}
+		require.ElementsMatch(t, keys(fromAPI), keys(fromCache))
+	})
+	t.Run("get", func(t *testing.T) {
+		secret, err := client.Get(context.TODO(), k8s.SecretReference{Namespace: "my-test-namespace", Name: "labeled-secret"})
+		require.NoError(t, err)
+		require.Equal(t, "value", string(secret.Data["key"]))
+
+		// Not cached, but exists
+		secret, err = client.Get(context.TODO(), k8s.SecretReference{Namespace: "my-test-namespace", Name: "not-labeled"})
+		require.NoError(t, err)
+		require.Equal(t, "not-labeled", secret.Name)
+	})
+	t.Run("hits", func(t *testing.T) {
+		counted := NewClient(socket, direct)
+		_, err := counted.ListCandidates(context.TODO(), types.ReplicatorLabel+"=true")
+		require.NoError(t, err)
+		_, err = counted.Get(context.TODO(), k8s.SecretReference{Namespace: "my-test-namespace", Name: "not-labeled"})
+		require.NoError(t, err)
+		require.Equal(t, 1, counted.Hits)
+		require.Equal(t, 1, counted.Misses)
+	})
+	t.Run("report", func(t *testing.T) {
+		err := client.Report(context.TODO(), metrics.Report{
+			App:         "my-app",
+			Project:     "default",
+			Destination: "my-test-namespace",
+			Result:      metrics.ResultSuccess,
+			Emitted:     2,
+			CacheHits:   1,
+		})
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		server.Metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Contains(t, recorder.Body.String(), `argocd_cmp_replicator_renders_total{app="my-app",project="default",result="success"} 1`)
+		require.Contains(t, recorder.Body.String(), `argocd_cmp_replicator_secrets_emitted_total{app="my-app",project="default"} 2`)
+	})
+	t.Run("report-without-metrics", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		NewServer(clientset, time.Minute).Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/v1/renders", strings.NewReader("{}")))
+		require.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+	t.Run("fallback", func(t *testing.T) {
+		missing := NewClient(filepath.Join(t.TempDir(), "missing.sock"), direct)
+		secrets, err := missing.ListCandidates(context.TODO(), types.ReplicatorLabel+"=true")
+		require.NoError(t, err)
+		require.Len(t, secrets, 2)
+
+		_, err = NewClient(filepath.Join(t.TempDir(), "missing.sock"), nil).ListCandidates(context.TODO(), "")
+		require.Error(t, err)
+	})
+
+	cancel()
+	require.NoError(t, <-served)
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/plumber-cd/argocd-cmp-replicator/metrics"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Client reads secrets from the cache server over a Unix socket.
+// If the server can't be reached, it falls back to the direct source.
+type Client struct {
+	Socket   string
+	Fallback k8s.SecretSource
+	// Hits and Misses count requests answered by the cache and the ones asked from the fallback
+	Hits       int
+	Misses     int
+	httpClient *http.Client
+}
+
+func NewClient(socket string, fallback k8s.SecretSource) *Client {
+	return &Client{
+		Socket:   socket,
+		Fallback: fallback,
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// Available is true if there is something listening on the socket path
+func Available(socket string) bool {
+	info, err := os.Stat(socket)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
+
+// ListCandidates implements k8s.SecretSource
+func (c *Client) ListCandidates(ctx context.Context, labelSelector string) ([]corev1.Secret, error) {
+	secrets := []corev1.Secret{}
+	err := c.get(ctx, "/v1/secrets?"+url.Values{"labelSelector": {labelSelector}}.Encode(), &secrets)
+	if err != nil {
+		if !c.fallback(err) {
+			return nil, err
+		}
+		return c.Fallback.ListCandidates(ctx, labelSelector)
+	}
+	c.Hits++
+	slog.Debug("Listed secrets from cache", "socket", c.Socket, "count", len(secrets))
+	return secrets, nil
+}
+
+// Get implements k8s.SecretSource
+func (c *Client) Get(ctx context.Context, ref k8s.SecretReference) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := c.get(ctx, "/v1/secrets/"+url.PathEscape(ref.Namespace)+"/"+url.PathEscape(ref.Name), secret)
+	if err != nil {
+		if !c.fallback(err) {
+			return nil, err
+		}
+		return c.Fallback.Get(ctx, ref)
+	}
+	c.Hits++
+	return secret, nil
+}
+
+// Describe implements k8s.SecretSource
+func (c *Client) Describe() string {
+	return "cache " + c.Socket
+}
+
+// fallback decides if the direct source must be asked instead.
+// The cache only holds labeled secrets, so it can't tell a secret does not exist.
+func (c *Client) fallback(err error) bool {
+	if c.Fallback == nil {
+		return false
+	}
+	c.Misses++
+	if apierrors.IsNotFound(err) {
+		slog.Debug("Secret not found in cache, asking the API", "socket", c.Socket, "err", err)
+		return true
+	}
+	slog.Warn("Secrets cache is not available, falling back to direct API calls", "socket", c.Socket, "err", err)
+	return true
+}
+
+// Report sends the render report to the server to be exposed as metrics
+func (c *Client) Report(ctx context.Context, report metrics.Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://unix/v1/renders", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("POST /v1/renders: %s %s", response.Status, body)
+	}
+	return nil
+}
+
+func (c *Client) get(ctx context.Context, path string, into interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://unix"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return apierrors.NewNotFound(corev1.Resource("secrets"), path)
+	}
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("GET %s: %s %s", path, response.Status, body)
+	}
+
+	return json.NewDecoder(response.Body).Decode(into)
+}
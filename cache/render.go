@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultRenderDir is where last good renders are kept, on the sidecar's tmp volume
+const DefaultRenderDir = "/tmp/argocd-cmp-replicator-renders"
+
+var ErrNoRender = errors.New("no last good render")
+
+// RenderCache keeps the last good render per Application encrypted on disk,
+// so it can be served when the API server is not reachable.
+type RenderCache struct {
+	Dir  string
+	aead cipher.AEAD
+}
+
+type render struct {
+	RenderedAt time.Time `json:"renderedAt"`
+	Output     []byte    `json:"output"`
+}
+
+// NewRenderCache derives AES-256 key from the contents of the key file, i.e. a mounted secret with random data
+func NewRenderCache(dir, keyFile string) (*RenderCache, error) {
+	secret, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("render cache key in %s is too short", keyFile)
+	}
+
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RenderCache{
+		Dir:  dir,
+		aead: aead,
+	}, nil
+}
+
+// RenderKey identifies the render of an Application, any input that changes the output must be part of it
+func RenderKey(parts ...string) string {
+	return strings.Join(parts, "\x00")
+}
+
+func (r *RenderCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(r.Dir, hex.EncodeToString(sum[:]))
+}
+
+// Store saves the output as the last good render
+func (r *RenderCache) Store(key string, output []byte, renderedAt time.Time) error {
+	plaintext, err := json.Marshal(render{
+		RenderedAt: renderedAt,
+		Output:     output,
+	})
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, r.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	// The key is authenticated, so a render of one Application can't be swapped for another
+	ciphertext := r.aead.Seal(nonce, nonce, plaintext, []byte(key))
+
+	if err := os.MkdirAll(r.Dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(r.Dir, ".render-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(ciphertext); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path(key))
+}
+
+// Load returns the last good render if it is not older than maxStaleness
+func (r *RenderCache) Load(key string, maxStaleness time.Duration, now time.Time) ([]byte, time.Time, error) {
+	ciphertext, err := os.ReadFile(r.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, time.Time{}, ErrNoRender
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	nonceSize := r.aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, time.Time{}, errors.New("last good render is corrupted")
+	}
+	plaintext, err := r.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], []byte(key))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to decrypt last good render: %w", err)
+	}
+
+	cached := render{}
+	if err := json.Unmarshal(plaintext, &cached); err != nil {
+		return nil, time.Time{}, err
+	}
+	if age := now.Sub(cached.RenderedAt); age > maxStaleness {
+		return nil, cached.RenderedAt, fmt.Errorf("last good render is %s old, more than allowed %s", age.Round(time.Second), maxStaleness)
+	}
+
+	return cached.Output, cached.RenderedAt, nil
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderCache(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("some-random-render-cache-key\n"), 0600))
+	otherKeyFile := filepath.Join(t.TempDir(), "other-key")
+	require.NoError(t, os.WriteFile(otherKeyFile, []byte("some-other-render-cache-key"), 0600))
+
+	dir := filepath.Join(t.TempDir(), "renders")
+	renderCache, err := NewRenderCache(dir, keyFile)
+	require.NoError(t, err)
+
+	key := RenderKey("my-app", "my-test-namespace", "")
+	renderedAt := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	output := []byte("apiVersion: v1\nkind: Secret\ndata:\n  key: dmFsdWU=\n")
+	require.NoError(t, renderCache.Store(key, output, renderedAt))
+
+	t.Run("encrypted", func(t *testing.T) {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+
+		data, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+		require.NoError(t, err)
+		require.NotContains(t, string(data), "dmFsdWU=")
+	})
+	t.Run("load", func(t *testing.T) {
+		loaded, at, err := renderCache.Load(key, time.Hour, renderedAt.Add(time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, output, loaded)
+		require.True(t, renderedAt.Equal(at))
+	})
+	t.Run("too-stale", func(t *testing.T) {
+		_, _, err := renderCache.Load(key, time.Hour, renderedAt.Add(2*time.Hour))
+		require.ErrorContains(t, err, "more than allowed 1h0m0s")
+	})
+	t.Run("other-app", func(t *testing.T) {
+		_, _, err := renderCache.Load(RenderKey("other-app", "my-test-namespace", ""), time.Hour, renderedAt)
+		require.ErrorIs(t, err, ErrNoRender)
+	})
+	t.Run("swapped", func(t *testing.T) {
+		otherKey := RenderKey("other-app", "my-test-namespace", "")
+		require.NoError(t, os.Rename(renderCache.path(key), renderCache.path(otherKey)))
+		defer func() { require.NoError(t, os.Rename(renderCache.path(otherKey), renderCache.path(key))) }()
+
+		_, _, err := renderCache.Load(otherKey, time.Hour, renderedAt)
+		require.ErrorContains(t, err, "failed to decrypt")
+	})
+	t.Run("wrong-key", func(t *testing.T) {
+		other, err := NewRenderCache(dir, otherKeyFile)
+		require.NoError(t, err)
+		_, _, err = other.Load(key, time.Hour, renderedAt)
+		require.ErrorContains(t, err, "failed to decrypt")
+	})
+	t.Run("short-key", func(t *testing.T) {
+		shortKeyFile := filepath.Join(t.TempDir(), "short")
+		require.NoError(t, os.WriteFile(shortKeyFile, []byte("short"), 0600))
+		_, err := NewRenderCache(dir, shortKeyFile)
+		require.ErrorContains(t, err, "too short")
+	})
+}
@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/metrics"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+)
+
+// DefaultSocket is where the server listens and the secrets command looks for it
+const DefaultSocket = "/tmp/argocd-cmp-replicator.sock"
+
+// Server keeps labeled secrets in an informer cache and serves them over a Unix socket,
+// so each render does not have to List secrets across the whole cluster.
+type Server struct {
+	// Metrics if set, renders may report to the server with Client.Report
+	Metrics   *metrics.Metrics
+	factories []informers.SharedInformerFactory
+	listers   []listersv1.SecretLister
+}
+
+// NewServer creates informers for each of the replicator labels - a selector can't express either of them.
+func NewServer(client kubernetes.Interface, resync time.Duration) *Server {
+	s := &Server{}
+	for _, label := range []string{types.ReplicatorLabel, types.ReplicatorLabelAlternative} {
+		selector := fmt.Sprintf("%s=%s", label, "true")
+		factory := informers.NewSharedInformerFactoryWithOptions(client, resync, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = selector
+		}))
+		s.factories = append(s.factories, factory)
+		s.listers = append(s.listers, factory.Core().V1().Secrets().Lister())
+	}
+	return s
+}
+
+// Start runs informers until the context is done and waits for the initial sync
+func (s *Server) Start(ctx context.Context) error {
+	for _, factory := range s.factories {
+		factory.Start(ctx.Done())
+	}
+	for _, factory := range s.factories {
+		for informer, synced := range factory.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				return fmt.Errorf("failed to sync informer cache for %v", informer)
+			}
+		}
+	}
+	slog.Info("Informer cache synced")
+	return nil
+}
+
+// List returns cached secrets matching the label selector, the same way Client.ListCandidates would
+func (s *Server) List(labelSelector string) ([]corev1.Secret, error) {
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	secrets := []corev1.Secret{}
+	for _, lister := range s.listers {
+		cached, err := lister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, secret := range cached {
+			key := secret.Namespace + "/" + secret.Name
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			// Cached objects are shared, never modify them
+			secret = secret.DeepCopy()
+			delete(secret.Annotations, types.ReplicatorAnnotationFromCluster)
+			secrets = append(secrets, *secret)
+		}
+	}
+	return secrets, nil
+}
+
+// Get returns a cached secret, only labeled secrets are cached
+func (s *Server) Get(namespace, name string) (*corev1.Secret, error) {
+	for _, lister := range s.listers {
+		secret, err := lister.Secrets(namespace).Get(name)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		secret = secret.DeepCopy()
+		delete(secret.Annotations, types.ReplicatorAnnotationFromCluster)
+		return secret, nil
+	}
+	return nil, apierrors.NewNotFound(corev1.Resource("secrets"), name)
+}
+
+// Handler serves the cache over HTTP
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/secrets", func(w http.ResponseWriter, r *http.Request) {
+		secrets, err := s.List(r.URL.Query().Get("labelSelector"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, secrets)
+	})
+	mux.HandleFunc("GET /v1/secrets/{namespace}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		secret, err := s.Get(r.PathValue("namespace"), r.PathValue("name"))
+		if apierrors.IsNotFound(err) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, secret)
+	})
+	if s.Metrics != nil {
+		mux.HandleFunc("POST /v1/renders", func(w http.ResponseWriter, r *http.Request) {
+			report := metrics.Report{}
+			if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.Metrics.Observe(report)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	return mux
+}
+
+// Serve listens on the Unix socket until the context is done.
+// The socket is only accessible to the same user, as it serves secret data.
+func (s *Server) Serve(ctx context.Context, socket string) error {
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	server := &http.Server{Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving secrets cache", "socket", socket)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write response", "err", err)
+	}
+}
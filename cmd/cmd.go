@@ -7,12 +7,19 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	exportCmd "github.com/plumber-cd/argocd-cmp-replicator/cmd/export"
+	lintCmd "github.com/plumber-cd/argocd-cmp-replicator/cmd/lint"
 	secretsCmd "github.com/plumber-cd/argocd-cmp-replicator/cmd/secrets"
+	serveCmd "github.com/plumber-cd/argocd-cmp-replicator/cmd/serve"
+	signCmd "github.com/plumber-cd/argocd-cmp-replicator/cmd/sign"
+	syncCmd "github.com/plumber-cd/argocd-cmp-replicator/cmd/sync"
 	versionCmd "github.com/plumber-cd/argocd-cmp-replicator/cmd/version"
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
 )
 
 var rootCmd = &cobra.Command{
@@ -39,6 +46,17 @@ func init() {
 
 	rootCmd.PersistentFlags().IntP("verbosity", "v", 0, "Set verbosity level")
 	rootCmd.PersistentFlags().String("log-format", "json", "Set log output (json, text)")
+	rootCmd.PersistentFlags().String("kube-api-server", "", "API server host to use with --kube-token-file (defaults to KUBERNETES_SERVICE_HOST:KUBERNETES_SERVICE_PORT)")
+	rootCmd.PersistentFlags().String("kube-token-file", "", "Path to a dedicated service account token to use instead of in-cluster config or kubeconfig")
+	rootCmd.PersistentFlags().String("kube-ca-file", "", "Path to the CA bundle to verify the API server with --kube-token-file")
+	rootCmd.PersistentFlags().String("kube-token-audience", "", "If set, the token from --kube-token-file must be issued for this audience")
+	rootCmd.PersistentFlags().Float32("kube-qps", 0, "Maximum queries per second to the API server (client-go default if 0)")
+	rootCmd.PersistentFlags().Int("kube-burst", 0, "Maximum burst of queries to the API server (client-go default if 0)")
+	rootCmd.PersistentFlags().Int("kube-retries", 4, "Attempts in total for API calls failing with retryable errors (429, 5xx, connection errors)")
+	rootCmd.PersistentFlags().Duration("kube-retry-backoff", 250*time.Millisecond, "Backoff before the first retry, doubled after each attempt with jitter")
+	rootCmd.PersistentFlags().Duration("kube-retry-max-backoff", 5*time.Second, "Maximum backoff between retries")
+	rootCmd.PersistentFlags().Int64("kube-page-size", k8s.DefaultPageSize, "How many secrets to list at once, negative disables pagination")
+	rootCmd.PersistentFlags().Bool("kube-use-index", false, "List only secrets with index labels for the destination namespace, see sync command")
 
 	if err := viper.BindPFlags(rootCmd.PersistentFlags()); err != nil {
 		log.Panic(err)
@@ -52,6 +70,11 @@ func init() {
 
 	rootCmd.AddCommand(versionCmd.Cmd)
 	rootCmd.AddCommand(secretsCmd.Cmd)
+	rootCmd.AddCommand(signCmd.Cmd)
+	rootCmd.AddCommand(lintCmd.Cmd)
+	rootCmd.AddCommand(exportCmd.Cmd)
+	rootCmd.AddCommand(serveCmd.Cmd)
+	rootCmd.AddCommand(syncCmd.Cmd)
 }
 
 func initConfig() {
@@ -0,0 +1,258 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/plumber-cd/argocd-cmp-replicator/cache"
+	"github.com/plumber-cd/argocd-cmp-replicator/cmd/version"
+	"github.com/plumber-cd/argocd-cmp-replicator/grants"
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/plumber-cd/argocd-cmp-replicator/sources"
+	"github.com/plumber-cd/argocd-cmp-replicator/tracing"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClientOptions reads global flags for the k8s client
+func ClientOptions() k8s.ClientOptions {
+	return k8s.ClientOptions{
+		Host:      viper.GetString("kube-api-server"),
+		TokenFile: viper.GetString("kube-token-file"),
+		CAFile:    viper.GetString("kube-ca-file"),
+		Audience:  viper.GetString("kube-token-audience"),
+		QPS:       float32(viper.GetFloat64("kube-qps")),
+		Burst:     viper.GetInt("kube-burst"),
+		PageSize:  viper.GetInt64("kube-page-size"),
+		UseIndex:  viper.GetBool("kube-use-index"),
+		Retry: k8s.Retry{
+			Attempts:   viper.GetInt("kube-retries"),
+			Backoff:    viper.GetDuration("kube-retry-backoff"),
+			MaxBackoff: viper.GetDuration("kube-retry-max-backoff"),
+		},
+	}
+}
+
+// AddTimeoutFlag registers the flag read by WithTimeout
+func AddTimeoutFlag(flags *pflag.FlagSet) {
+	flags.Duration("timeout", 60*time.Second, "Overall timeout of the command, 0 disables it - keep it below ArgoCD exec timeout so retries don't outlive the render")
+}
+
+// WithTimeout bounds the command context with the timeout flag registered with AddTimeoutFlag
+func WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := viper.GetDuration("timeout")
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// AddTracingFlags registers flags read by Tracing
+func AddTracingFlags(flags *pflag.FlagSet) {
+	flags.String("trace-exporter", tracing.ExporterNone, "Where to export OpenTelemetry spans (none, otlp, stderr, file) - otlp is configured with OTEL_EXPORTER_OTLP_* environment variables")
+	flags.String("trace-file", "", "File to append spans to as JSON lines with --trace-exporter=file")
+}
+
+// Tracing sets up the exporter from flags registered with AddTracingFlags.
+// Returned function flushes spans, it never fails the command.
+func Tracing(ctx context.Context) (func(), error) {
+	shutdown, err := tracing.Setup(ctx, tracing.Options{
+		Exporter: viper.GetString("trace-exporter"),
+		File:     viper.GetString("trace-file"),
+		Version:  version.Version,
+	})
+	if err != nil {
+		slog.Error("Failed to set up tracing", "err", err)
+		return nil, err
+	}
+	return func() {
+		// The command context may be done by now, spans of a timed out render are the interesting ones
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			slog.Warn("Failed to flush spans", "err", err)
+		}
+	}, nil
+}
+
+// AddPolicyFlags registers flags read by Policy on commands that need them
+func AddPolicyFlags(flags *pflag.FlagSet) {
+	flags.String("invalid-secrets", k8s.InvalidSecretsWarn, "What to do with secrets of known types that have invalid data (fail, skip, warn)")
+	flags.Duration("cert-expiry-warning", 30*24*time.Hour, "Warn when a replicated certificate expires within this duration")
+	flags.Bool("refuse-expired-certs", true, "Fail the render when a replicated certificate has expired")
+	flags.Duration("max-secret-age", 0, "Secrets not rotated for longer than this are stale, 0 disables the check")
+	flags.String("stale-secrets", k8s.StaleSecretsMark, "What to do with stale secrets (mark, exclude)")
+	flags.StringSlice("trusted-field-managers", []string{}, "If set, replicator labels and annotations on the source secret must only be managed by these field managers")
+	flags.StringSlice("trusted-grant-keys", []string{}, "Paths to PEM encoded ed25519 public keys. If set, allowed-namespaces and replicated-name annotations must be signed by one of them")
+}
+
+// Policy reads flags registered with AddPolicyFlags
+func Policy() (k8s.Policy, error) {
+	policy := k8s.Policy{
+		TrustedFieldManagers: viper.GetStringSlice("trusted-field-managers"),
+		InvalidSecrets:       viper.GetString("invalid-secrets"),
+		CertExpiryWarning:    viper.GetDuration("cert-expiry-warning"),
+		RefuseExpiredCerts:   viper.GetBool("refuse-expired-certs"),
+		MaxSecretAge:         viper.GetDuration("max-secret-age"),
+		StaleSecrets:         viper.GetString("stale-secrets"),
+	}
+
+	if keyFiles := viper.GetStringSlice("trusted-grant-keys"); len(keyFiles) > 0 {
+		keys, err := grants.LoadPublicKeys(keyFiles)
+		if err != nil {
+			slog.Error("Failed to load trusted grant keys", "err", err)
+			return policy, err
+		}
+		slog.Debug("Loaded trusted grant keys", "count", len(keys))
+		policy.TrustedGrantKeys = keys
+	}
+
+	if policy.InvalidSecrets != k8s.InvalidSecretsFail && policy.InvalidSecrets != k8s.InvalidSecretsSkip && policy.InvalidSecrets != k8s.InvalidSecretsWarn {
+		slog.Error("Unknown invalid secrets policy", "value", policy.InvalidSecrets)
+		return policy, fmt.Errorf("Unknown invalid secrets policy: %s", policy.InvalidSecrets)
+	}
+
+	if policy.StaleSecrets != k8s.StaleSecretsMark && policy.StaleSecrets != k8s.StaleSecretsExclude {
+		slog.Error("Unknown stale secrets policy", "value", policy.StaleSecrets)
+		return policy, fmt.Errorf("Unknown stale secrets policy: %s", policy.StaleSecrets)
+	}
+
+	return policy, nil
+}
+
+// AddSourceFlags registers flags read by Sources
+func AddSourceFlags(flags *pflag.FlagSet) {
+	flags.StringSlice("sources", []string{"kubernetes"}, "Where to find secrets, each is type[:argument] - supported types: kubernetes, cluster:<ArgoCD cluster name or server>, dir:<path>, vault:<KV v2 mount>[/path], repo[:<path>], bundle:<path>")
+	flags.String("cache-socket", cache.DefaultSocket, "Read kubernetes source from the cache served on this socket if it exists, empty disables")
+	flags.String("source-dir", "", "Read secrets only from manifests in this file or directory, overrides --sources")
+	flags.String("vault-addr", "", "Vault server address for vault sources")
+	flags.String("vault-namespace", "", "Vault Enterprise namespace for vault sources")
+	flags.String("vault-ca-file", "", "CA bundle to verify Vault server")
+	flags.String("vault-token-file", "", "Vault token file, if not set Kubernetes auth is used")
+	flags.String("vault-auth-mount", "kubernetes", "Vault Kubernetes auth method mount")
+	flags.String("vault-role", "", "Vault Kubernetes auth role")
+	flags.String("vault-jwt-file", "/var/run/secrets/kubernetes.io/serviceaccount/token", "Service account token to login with Vault Kubernetes auth")
+	flags.String("age-identity-secret", "argocd-cmp-replicator-age", "Secret in --argocd-namespace with age identities to decrypt repo and bundle sources")
+	flags.String("age-identity-key", "keys.txt", "Key in --age-identity-secret with age identities")
+	flags.StringSlice("bundle-keys", []string{}, "Paths to PEM encoded ed25519 public keys, bundle sources must be signed by one of them")
+	flags.String("argocd-namespace", "argocd", "Namespace where ArgoCD runs, for its cluster secrets and Applications")
+}
+
+// Sources builds secret sources from flags registered with AddSourceFlags.
+// The client is only connected to the cluster if any of the sources needs it.
+// Namespace is the destination, repo sources place their secrets there.
+func Sources(ctx context.Context, client *k8s.Client, namespace string) ([]k8s.SecretSource, error) {
+	connect := func() error {
+		if client.Interface != nil {
+			return nil
+		}
+		_client, err := k8s.New(ClientOptions())
+		if err != nil {
+			slog.Error("Failed to create k8s client", "err", err)
+			return err
+		}
+		client.Interface = _client.Interface
+		client.ArgoCD = _client.ArgoCD
+		client.Options = _client.Options
+		return nil
+	}
+
+	ageIdentities := func() ([]age.Identity, error) {
+		if err := connect(); err != nil {
+			return nil, err
+		}
+		argocdNamespace := viper.GetString("argocd-namespace")
+		secretName := viper.GetString("age-identity-secret")
+		secret, err := client.CoreV1().Secrets(argocdNamespace).Get(ctx, secretName, metav1.GetOptions{})
+		if err != nil {
+			slog.Error("Failed to get age identity secret", "namespace", argocdNamespace, "name", secretName, "err", err)
+			return nil, err
+		}
+		return sources.AgeIdentities(secret, viper.GetString("age-identity-key"))
+	}
+
+	specs := viper.GetStringSlice("sources")
+	if dir := viper.GetString("source-dir"); dir != "" {
+		specs = []string{"dir:" + dir}
+	}
+
+	configured := []k8s.SecretSource{}
+	for _, spec := range specs {
+		kind, arg, _ := strings.Cut(spec, ":")
+		if arg == "" && kind != "kubernetes" && kind != "repo" {
+			return nil, fmt.Errorf("Source %s requires an argument", spec)
+		}
+		switch kind {
+		case "kubernetes":
+			if err := connect(); err != nil {
+				return nil, err
+			}
+			if socket := viper.GetString("cache-socket"); socket != "" && cache.Available(socket) {
+				configured = append(configured, cache.NewClient(socket, client))
+			} else {
+				configured = append(configured, client)
+			}
+		case "cluster":
+			if err := connect(); err != nil {
+				return nil, err
+			}
+			cluster, err := client.NewForArgoCDCluster(ctx, viper.GetString("argocd-namespace"), arg)
+			if err != nil {
+				slog.Error("Failed to create client for ArgoCD cluster", "cluster", arg, "err", err)
+				return nil, err
+			}
+			configured = append(configured, cluster)
+		case "dir":
+			configured = append(configured, sources.NewDir(arg))
+		case "vault":
+			vault, err := sources.NewVault(sources.VaultOptions{
+				Address:   viper.GetString("vault-addr"),
+				Namespace: viper.GetString("vault-namespace"),
+				CAFile:    viper.GetString("vault-ca-file"),
+				TokenFile: viper.GetString("vault-token-file"),
+				AuthMount: viper.GetString("vault-auth-mount"),
+				Role:      viper.GetString("vault-role"),
+				JWTFile:   viper.GetString("vault-jwt-file"),
+			}, arg)
+			if err != nil {
+				return nil, err
+			}
+			configured = append(configured, vault)
+		case "repo":
+			identities, err := ageIdentities()
+			if err != nil {
+				return nil, err
+			}
+			if arg == "" {
+				arg = "."
+			}
+			configured = append(configured, sources.NewRepo(arg, namespace, identities))
+		case "bundle":
+			keyFiles := viper.GetStringSlice("bundle-keys")
+			if len(keyFiles) == 0 {
+				return nil, errors.New("--bundle-keys must be set for bundle sources")
+			}
+			keys, err := grants.LoadPublicKeys(keyFiles)
+			if err != nil {
+				slog.Error("Failed to load bundle keys", "err", err)
+				return nil, err
+			}
+			identities, err := ageIdentities()
+			if err != nil {
+				return nil, err
+			}
+			configured = append(configured, sources.NewBundle(arg, namespace, keys, identities))
+		default:
+			slog.Error("Unknown source", "source", spec)
+			return nil, fmt.Errorf("Unknown source: %s", spec)
+		}
+		slog.Debug("Configured source", "source", configured[len(configured)-1].Describe())
+	}
+	return configured, nil
+}
@@ -0,0 +1,120 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+
+	"filippo.io/age"
+	"github.com/plumber-cd/argocd-cmp-replicator/bundle"
+	"github.com/plumber-cd/argocd-cmp-replicator/cmd/common"
+	"github.com/plumber-cd/argocd-cmp-replicator/grants"
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	Cmd.Flags().String("namespace", "", "Target namespace in the disconnected site, only secrets allowed for it are exported")
+	Cmd.Flags().StringP("alternative-label-selector", "l", "", "This is a list of key=value pairs. If set, will override default label selector")
+	Cmd.Flags().StringSlice("recipient", []string{}, "age public key (age1...) to encrypt the bundle to, can be repeated")
+	Cmd.Flags().String("recipients-file", "", "File with age public keys to encrypt the bundle to, one per line")
+	Cmd.Flags().String("private-key", "", "Path to PEM encoded ed25519 private key to sign the bundle")
+	Cmd.Flags().StringP("output", "o", "", "Write the bundle to this file instead of stdout")
+	common.AddPolicyFlags(Cmd.Flags())
+	common.AddTimeoutFlag(Cmd.Flags())
+	common.AddSourceFlags(Cmd.Flags())
+}
+
+// Cmd will write a signed and encrypted bundle of secrets for a disconnected site
+var Cmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export secrets allowed for a namespace into a signed encrypted bundle",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := common.WithTimeout(cmd.Context())
+		defer cancel()
+
+		namespace := viper.GetString("namespace")
+		if namespace == "" {
+			return errors.New("--namespace is required")
+		}
+
+		privateKeyPath := viper.GetString("private-key")
+		if privateKeyPath == "" {
+			return errors.New("--private-key is required")
+		}
+		key, err := grants.LoadPrivateKey(privateKeyPath)
+		if err != nil {
+			slog.Error("Failed to load private key", "err", err)
+			return err
+		}
+
+		recipients := []age.Recipient{}
+		for _, r := range viper.GetStringSlice("recipient") {
+			recipient, err := age.ParseX25519Recipient(r)
+			if err != nil {
+				return err
+			}
+			recipients = append(recipients, recipient)
+		}
+		if recipientsFile := viper.GetString("recipients-file"); recipientsFile != "" {
+			data, err := os.ReadFile(recipientsFile)
+			if err != nil {
+				return err
+			}
+			fromFile, err := age.ParseRecipients(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			recipients = append(recipients, fromFile...)
+		}
+		if len(recipients) == 0 {
+			return errors.New("--recipient or --recipients-file is required")
+		}
+
+		policy, err := common.Policy()
+		if err != nil {
+			return err
+		}
+		client := &k8s.Client{
+			Policy: policy,
+		}
+
+		sources, err := common.Sources(ctx, client, namespace)
+		if err != nil {
+			return err
+		}
+		client.Sources = sources
+
+		secrets, err := client.GetLabeledSecrets(ctx, namespace, viper.GetString("alternative-label-selector"))
+		if err != nil {
+			slog.Error("Failed to get secrets", "err", err)
+			return err
+		}
+
+		sealed, err := bundle.Seal(secrets.Items, namespace, recipients, key)
+		if err != nil {
+			slog.Error("Failed to seal bundle", "err", err)
+			return err
+		}
+
+		var out io.Writer = os.Stdout
+		if output := viper.GetString("output"); output != "" {
+			f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+
+		if err := sealed.Write(out); err != nil {
+			return err
+		}
+
+		slog.Info("Exported secrets", "count", len(secrets.Items), "namespace", namespace, "recipients", len(recipients))
+		return nil
+	},
+}
@@ -0,0 +1,59 @@
+package lint
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/cmd/common"
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"sigs.k8s.io/yaml"
+)
+
+func init() {
+	Cmd.Flags().Duration("expiry-horizon", 14*24*time.Hour, "Report grants expiring within this duration")
+	common.AddPolicyFlags(Cmd.Flags())
+	common.AddTimeoutFlag(Cmd.Flags())
+}
+
+// Cmd will print findings for all replicable secrets in the cluster
+var Cmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Report problems with replicable secrets in the cluster",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := common.WithTimeout(cmd.Context())
+		defer cancel()
+
+		client, err := k8s.New(common.ClientOptions())
+		if err != nil {
+			slog.Error("Failed to create k8s client", "err", err)
+			return err
+		}
+
+		policy, err := common.Policy()
+		if err != nil {
+			return err
+		}
+		client.Policy = policy
+
+		findings, err := client.Lint(ctx, k8s.LintOptions{
+			ExpiryHorizon: viper.GetDuration("expiry-horizon"),
+		})
+		if err != nil {
+			slog.Error("Failed to lint secrets", "err", err)
+			return err
+		}
+
+		slog.Info("Lint finished", "findings", len(findings))
+
+		out, err := yaml.Marshal(findings)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(string(out))
+		return nil
+	},
+}
@@ -1,21 +1,55 @@
 package secrets
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	argocdv1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/plumber-cd/argocd-cmp-replicator/audit"
+	"github.com/plumber-cd/argocd-cmp-replicator/cache"
+	"github.com/plumber-cd/argocd-cmp-replicator/cmd/common"
 	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/plumber-cd/argocd-cmp-replicator/metrics"
+	"github.com/plumber-cd/argocd-cmp-replicator/sources"
+	"github.com/plumber-cd/argocd-cmp-replicator/tracing"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/cli-runtime/pkg/printers"
 	"sigs.k8s.io/yaml"
 )
 
 func init() {
 	Cmd.PersistentFlags().String("namespace", "", "Namespace to search for secrets - this is ignored if ARGOCD_APP_NAMESPACE is set")
 	Cmd.PersistentFlags().StringP("alternative-label-selector", "l", "", "This is a list of key=value pairs. If set, will override default label selector")
+	Cmd.PersistentFlags().String("render-cache-key-file", "", "File with a secret to encrypt last good renders, if set they are served when the API is not available")
+	Cmd.PersistentFlags().String("render-cache-dir", cache.DefaultRenderDir, "Where to keep last good renders")
+	Cmd.PersistentFlags().Duration("render-cache-max-staleness", 24*time.Hour, "Do not serve last good renders older than this")
+	Cmd.PersistentFlags().Bool("events", false, "Record Events on source secrets when they are replicated or denied")
+	Cmd.PersistentFlags().Duration("events-interval", time.Hour, "Repeats of the same event only update it once per this interval")
+	Cmd.PersistentFlags().String("destination-cluster", "in-cluster", "Name of the cluster the Application deploys to, for events and consumer status - set it per Application with the plugin env")
+	Cmd.PersistentFlags().String("consumer-status", "", "Write back consuming Applications to source secrets: annotation or configmap, off if empty")
+	Cmd.PersistentFlags().Duration("consumer-status-ttl", 7*24*time.Hour, "Prune consumers not seen within this long")
+	Cmd.PersistentFlags().Duration("consumer-status-interval", time.Hour, "Only refresh the last render of the same consumer once per this interval")
+	Cmd.PersistentFlags().String("removal-protection", "", "What to do when the render would remove replicas the Application manages: fail or keep (the copies from the last good render), off if empty")
+	Cmd.PersistentFlags().Bool("allow-removals", false, "Allow the render to remove replicas with --removal-protection - the allow-removals parameter does the same per Application")
+	Cmd.PersistentFlags().String("sync-wave", "", "ArgoCD sync wave of replicas - the sync-wave parameter replaces it per Application")
+	Cmd.PersistentFlags().String("sync-options", "", "Comma separated ArgoCD sync options of replicas, i.e. Prune=false - the sync-options parameter replaces it per Application")
+	Cmd.PersistentFlags().String("compare-options", "", "Comma separated ArgoCD compare options of replicas, i.e. IgnoreExtraneous - the compare-options parameter replaces it per Application")
+	Cmd.PersistentFlags().String("audit-log", "", "File to append replication decisions to as JSON lines, or stderr - no values are recorded, only digests")
+	common.AddPolicyFlags(Cmd.PersistentFlags())
+	common.AddTimeoutFlag(Cmd.PersistentFlags())
+	common.AddSourceFlags(Cmd.PersistentFlags())
+	common.AddTracingFlags(Cmd.PersistentFlags())
 }
 
 type K8sClient struct {
@@ -26,73 +60,479 @@ type K8sClient struct {
 var Cmd = &cobra.Command{
 	Use:   "secrets",
 	Short: "Find secrets matching given criteria",
-	RunE: func(cmd *cobra.Command, args []string) error {
-		ctx := cmd.Context()
-
-		namespace := os.Getenv("ARGOCD_APP_NAMESPACE")
-		namespaceFromArg := viper.GetString("namespace")
-		if namespace == "" {
-			namespace = namespaceFromArg
-		} else if namespaceFromArg != "" {
-			slog.Error("Namespace is set as ARGOCD_APP_NAMESPACE, not allowed to set namespace as an argument")
-		}
-		if namespace == "" {
-			slog.Error("Namespace not set")
-			return errors.New("Namespace not set")
-		}
-
-		alternativeLabelSelector := ""
-		if v, ok := os.LookupEnv("ARGOCD_APP_PARAMETERS"); ok {
-			if viper.GetString("alternative-label-selector") != "" {
-				slog.Error("Both ARGOCD_APP_PARAMETERS and --alternative-label-selector were set")
-				return fmt.Errorf("Both ARGOCD_APP_PARAMETERS and --alternative-label-selector were set")
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		ctx, cancel := common.WithTimeout(cmd.Context())
+		defer cancel()
+
+		shutdown, err := common.Tracing(ctx)
+		if err != nil {
+			return err
+		}
+		defer shutdown()
+
+		ctx, span := tracing.Tracer().Start(ctx, "render", trace.WithAttributes(
+			tracing.AttributeApp.String(os.Getenv("ARGOCD_APP_NAME")),
+			tracing.AttributeProject.String(os.Getenv("ARGOCD_APP_PROJECT_NAME")),
+		))
+		defer func() {
+			tracing.End(span, err)
+		}()
+
+		params, err := parseParameters(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		namespace, alternativeLabelSelector := params.Namespace, params.AlternativeLabelSelector
+		span.SetAttributes(tracing.AttributeDestination.String(namespace))
+
+		policy, err := common.Policy()
+		if err != nil {
+			return err
+		}
+		stats := &k8s.Stats{}
+		_client := &k8s.Client{
+			Policy:        policy,
+			Stats:         stats,
+			ArgoCDOptions: params.ArgoCDOptions,
+		}
+
+		start := time.Now()
+		report := metrics.Report{
+			App:         os.Getenv("ARGOCD_APP_NAME"),
+			Project:     os.Getenv("ARGOCD_APP_PROJECT_NAME"),
+			Destination: namespace,
+			Result:      metrics.ResultError,
+		}
+		// The render is over before events and consumer status are published, those must not count
+		measure := func() {
+			if report.DurationSeconds == 0 {
+				report.DurationSeconds = time.Since(start).Seconds()
 			}
-			slog.Debug("ARGOCD_APP_PARAMETERS", "value", v)
-			params := argocdv1alpha1.ApplicationSourcePluginParameters{}
-			if err := yaml.Unmarshal([]byte(v), &params); err != nil {
-				return err
+		}
+		defer func() {
+			measure()
+			report.Skipped = stats.Skipped
+			report.Certificates = stats.Certificates
+			sendReport(report, _client.Sources)
+		}()
+
+		auditLog, err := audit.Open(viper.GetString("audit-log"))
+		if err != nil {
+			slog.Error("Failed to open audit log", "err", err)
+			return err
+		}
+		// Secrets must not be replicated without a trace when the audit log is on
+		var auditErr error
+		var onDecision []func(corev1.Secret, k8s.Decision)
+		_client.OnDecision = func(secret corev1.Secret, decision k8s.Decision) {
+			for _, f := range onDecision {
+				f(secret, decision)
 			}
-			for _, param := range params {
-				if param.Name == "alternative-label-selector" {
-					if param.String_ == nil {
-						slog.Error("alternative-label-selector is not a string")
-						return fmt.Errorf("alternative-label-selector is not a string")
-					}
-					alternativeLabelSelector = *param.String_
-					break
+		}
+		if auditLog != nil {
+			defer auditLog.Close()
+			auditRender := audit.NewRender(report.App, report.Project, os.Getenv("ARGOCD_APP_REVISION"), namespace)
+			onDecision = append(onDecision, func(_ corev1.Secret, decision k8s.Decision) {
+				if err := auditLog.Decision(auditRender, decision); err != nil && auditErr == nil {
+					auditErr = err
 				}
-			}
-		} else {
-			_alternativeLabelSelector, err := cmd.Flags().GetString("alternative-label-selector")
+			})
+			defer func() {
+				if err := auditLog.Finish(auditRender, report.Result, report.Emitted); err != nil {
+					slog.Error("Failed to write audit log", "err", err)
+				}
+			}()
+		}
+
+		clientCtx, clientSpan := tracing.Tracer().Start(ctx, "create client")
+		sources, err := common.Sources(clientCtx, _client, namespace)
+		tracing.End(clientSpan, err)
+		if err != nil {
+			return err
+		}
+		_client.Sources = sources
+
+		// Without a client no source reads from the API, so there are no secrets to record events on
+		if viper.GetBool("events") && _client.Interface != nil {
+			events := k8s.NewEventRecorder(_client.Interface, viper.GetDuration("events-interval"))
+			events.App = report.App
+			events.Destination = viper.GetString("destination-cluster") + "/" + namespace
+			onDecision = append(onDecision, events.Observe)
+			defer func() {
+				// The render context may be done by now, that's what might be worth an event
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				events.Publish(ctx, report.Result == metrics.ResultSuccess)
+				events.Shutdown()
+			}()
+		}
+
+		// Same as events, only secrets read from the API can be written back
+		if mode := viper.GetString("consumer-status"); mode != "" && _client.Interface != nil {
+			status, err := k8s.NewConsumerStatus(_client.Interface, mode)
 			if err != nil {
 				return err
 			}
-			alternativeLabelSelector = _alternativeLabelSelector
+			status.Consumer = k8s.Consumer{
+				App:         report.App,
+				Project:     report.Project,
+				Destination: viper.GetString("destination-cluster") + "/" + namespace,
+			}
+			status.TTL = viper.GetDuration("consumer-status-ttl")
+			status.Interval = viper.GetDuration("consumer-status-interval")
+			onDecision = append(onDecision, status.Observe)
+			defer func() {
+				if report.Result != metrics.ResultSuccess {
+					return
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				// Status is informational, it must never fail the render
+				_ = status.Publish(ctx)
+			}()
 		}
+		// Deferred after publishing, so it runs before it
+		defer measure()
 
-		_client, err := k8s.New()
+		client := K8sClient{
+			_client,
+		}
+
+		renderCache, err := newRenderCache()
 		if err != nil {
-			slog.Error("Failed to create k8s client", "err", err)
 			return err
 		}
 
-		client := K8sClient{
-			_client,
+		removalProtection := viper.GetString("removal-protection")
+		switch removalProtection {
+		case "", removalProtectionFail:
+		case removalProtectionKeep:
+			if renderCache == nil {
+				return errors.New("--removal-protection=keep requires --render-cache-key-file")
+			}
+		default:
+			return fmt.Errorf("Unknown removal protection: %s", removalProtection)
 		}
+		// Replicas of retired secrets may be removed
+		retired := []string{}
+		onDecision = append(onDecision, func(secret corev1.Secret, decision k8s.Decision) {
+			if decision.Reason == k8s.ReasonRetired {
+				retired = append(retired, k8s.ReplicaName(secret))
+			}
+		})
+		renderKey := cache.RenderKey(
+			os.Getenv("ARGOCD_APP_NAME"),
+			namespace,
+			alternativeLabelSelector,
+			params.ArgoCDOptions.SyncWave,
+			params.ArgoCDOptions.SyncOptions,
+			params.ArgoCDOptions.CompareOptions,
+		)
 
 		secrets, err := client.GetLabeledSecrets(ctx, namespace, alternativeLabelSelector)
+		sourceErr := &k8s.SourceError{}
+		if err != nil && errors.As(err, &sourceErr) {
+			report.ErrorReason = k8s.ErrorReason(err)
+			if renderCache != nil {
+				if staleErr := serveLastGoodRender(renderCache, renderKey, err); staleErr != nil {
+					return staleErr
+				}
+				span.RecordError(err)
+				report.Result = metrics.ResultStale
+				return nil
+			}
+		}
 		if err != nil {
-			slog.Error("Failed to get secrets", "err", err)
+			report.ErrorReason = k8s.ErrorReason(err)
+			slog.Error("Failed to get secrets", "reason", report.ErrorReason, "err", err)
 			return err
 		}
 
 		slog.Info("Filtered secrets", "count", len(secrets.Items))
 
-		if err := client.WriteSecretListManifests(ctx, namespace, secrets, os.Stdout); err != nil {
-			slog.Error("Failed to write secrets", "err", err)
+		kept := []corev1.Secret{}
+		if removalProtection != "" && !params.AllowRemovals {
+			// Replica names must be checked before the secrets are written, the writer modifies their annotations
+			kept, err = protectRemovals(ctx, _client, namespace, secrets, retired, removalProtection, renderCache, renderKey)
+			if err != nil {
+				report.ErrorReason = k8s.ErrorReason(err)
+				return err
+			}
+		}
+
+		if auditErr != nil {
+			slog.Error("Failed to write audit log", "err", auditErr)
+			return auditErr
+		}
+
+		// Render fully before writing anything, so a failure does not leave partial output
+		buf := &bytes.Buffer{}
+		if err := client.WriteSecretListManifests(ctx, namespace, secrets, buf); err != nil {
+			report.ErrorReason = k8s.ErrorReason(err)
+			slog.Error("Failed to write secrets", "reason", report.ErrorReason, "err", err)
+			return err
+		}
+		printer := printers.YAMLPrinter{}
+		for _, replica := range kept {
+			// Each printer only separates objects it printed itself
+			if buf.Len() > 0 {
+				buf.WriteString("---\n")
+			}
+			if err := printer.PrintObj(&replica, buf); err != nil {
+				return err
+			}
+		}
+
+		if renderCache != nil {
+			if err := renderCache.Store(renderKey, buf.Bytes(), time.Now()); err != nil {
+				slog.Warn("Failed to store last good render", "err", err)
+			}
+		}
+
+		if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
 			return err
 		}
 
+		report.Result = metrics.ResultSuccess
+		report.Emitted = len(secrets.Items) + len(kept)
 		return nil
 	},
 }
+
+// sendReport sends the render report to the serve command for metrics, if it is running.
+// Metrics must never fail the render, so errors are only logged.
+func sendReport(report metrics.Report, sources []k8s.SecretSource) {
+	socket := viper.GetString("cache-socket")
+	if socket == "" || !cache.Available(socket) {
+		return
+	}
+
+	for _, source := range sources {
+		if cacheClient, ok := source.(*cache.Client); ok {
+			report.CacheHits += cacheClient.Hits
+			report.CacheMisses += cacheClient.Misses
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := cache.NewClient(socket, nil).Report(ctx, report); err != nil {
+		slog.Debug("Failed to send render report", "socket", socket, "err", err)
+	}
+}
+
+// parameters of the render, from ArgoCD environment or flags
+type parameters struct {
+	Namespace                string
+	AlternativeLabelSelector string
+	AllowRemovals            bool
+	ArgoCDOptions            k8s.ArgoCDOptions
+}
+
+// parseParameters reads the destination namespace and plugin parameters from ArgoCD environment or flags
+func parseParameters(ctx context.Context, cmd *cobra.Command) (result parameters, err error) {
+	_, span := tracing.Tracer().Start(ctx, "parse parameters")
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	result.Namespace = os.Getenv("ARGOCD_APP_NAMESPACE")
+	namespaceFromArg := viper.GetString("namespace")
+	if result.Namespace == "" {
+		result.Namespace = namespaceFromArg
+	} else if namespaceFromArg != "" {
+		slog.Error("Namespace is set as ARGOCD_APP_NAMESPACE, not allowed to set namespace as an argument")
+	}
+	if result.Namespace == "" {
+		slog.Error("Namespace not set")
+		return parameters{}, errors.New("Namespace not set")
+	}
+
+	result.AllowRemovals = viper.GetBool("allow-removals")
+	syncWave, syncOptions, compareOptions := viper.GetString("sync-wave"), viper.GetString("sync-options"), viper.GetString("compare-options")
+
+	if v, ok := os.LookupEnv("ARGOCD_APP_PARAMETERS"); ok {
+		if viper.GetString("alternative-label-selector") != "" {
+			slog.Error("Both ARGOCD_APP_PARAMETERS and --alternative-label-selector were set")
+			return parameters{}, fmt.Errorf("Both ARGOCD_APP_PARAMETERS and --alternative-label-selector were set")
+		}
+		slog.Debug("ARGOCD_APP_PARAMETERS", "value", v)
+		params := argocdv1alpha1.ApplicationSourcePluginParameters{}
+		if err := yaml.Unmarshal([]byte(v), &params); err != nil {
+			return parameters{}, err
+		}
+		for _, param := range params {
+			switch param.Name {
+			case "alternative-label-selector":
+				if param.String_ == nil {
+					slog.Error("alternative-label-selector is not a string")
+					return parameters{}, fmt.Errorf("alternative-label-selector is not a string")
+				}
+				result.AlternativeLabelSelector = *param.String_
+			case "allow-removals":
+				if param.String_ == nil {
+					slog.Error("allow-removals is not a string")
+					return parameters{}, fmt.Errorf("allow-removals is not a string")
+				}
+				allow, err := strconv.ParseBool(*param.String_)
+				if err != nil {
+					slog.Error("allow-removals is not a boolean", "value", *param.String_)
+					return parameters{}, fmt.Errorf("allow-removals is not a boolean: %w", err)
+				}
+				result.AllowRemovals = result.AllowRemovals || allow
+			case "sync-wave":
+				if syncWave, err = stringParameter(param); err != nil {
+					return parameters{}, err
+				}
+			case "sync-options":
+				if syncOptions, err = stringParameter(param); err != nil {
+					return parameters{}, err
+				}
+			case "compare-options":
+				if compareOptions, err = stringParameter(param); err != nil {
+					return parameters{}, err
+				}
+			}
+		}
+	} else {
+		result.AlternativeLabelSelector, err = cmd.Flags().GetString("alternative-label-selector")
+		if err != nil {
+			return parameters{}, err
+		}
+	}
+
+	result.ArgoCDOptions, err = k8s.ParseArgoCDOptions(syncWave, syncOptions, compareOptions)
+	if err != nil {
+		slog.Error("Invalid ArgoCD options", "err", err)
+		return parameters{}, err
+	}
+
+	return result, nil
+}
+
+// stringParameter returns the value of the plugin parameter that must be a string
+func stringParameter(param argocdv1alpha1.ApplicationSourcePluginParameter) (string, error) {
+	if param.String_ == nil {
+		slog.Error("Parameter is not a string", "name", param.Name)
+		return "", fmt.Errorf("%s is not a string", param.Name)
+	}
+	return *param.String_, nil
+}
+
+const (
+	removalProtectionFail = "fail"
+	removalProtectionKeep = "keep"
+)
+
+// protectRemovals compares the render with Secrets the Application manages, and returns copies of replicas to keep.
+// With fail, or if there are no copies to keep, it returns an error wrapping k8s.ErrRemovalDenied instead.
+func protectRemovals(
+	ctx context.Context,
+	client *k8s.Client,
+	namespace string,
+	secrets *corev1.SecretList,
+	retired []string,
+	mode string,
+	renderCache *cache.RenderCache,
+	renderKey string,
+) (kept []corev1.Secret, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "protect removals")
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	appName := os.Getenv("ARGOCD_APP_NAME")
+	if appName == "" {
+		slog.Warn("ARGOCD_APP_NAME is not set, can't tell which replicas would be removed")
+		return nil, nil
+	}
+
+	// Sources may not need the API, but the Application is only there
+	if client.ArgoCD == nil {
+		_client, err := k8s.New(common.ClientOptions())
+		if err != nil {
+			slog.Error("Failed to create k8s client", "err", err)
+			return nil, err
+		}
+		client.ArgoCD = _client.ArgoCD
+	}
+	app, err := client.GetApplication(ctx, viper.GetString("argocd-namespace"), appName)
+	if err != nil {
+		slog.Error("Failed to get Application", "name", appName, "reason", k8s.ErrorReason(err), "err", err)
+		return nil, err
+	}
+
+	removals := k8s.Removals(k8s.ManagedSecrets(app, namespace), secrets, retired)
+	if len(removals) == 0 {
+		return nil, nil
+	}
+	if mode == removalProtectionFail {
+		slog.Error("Render would remove replicas, retire their sources or allow removals", "replicas", removals)
+		return nil, fmt.Errorf("%w: render would remove %s", k8s.ErrRemovalDenied, strings.Join(removals, ", "))
+	}
+
+	maxStaleness := viper.GetDuration("render-cache-max-staleness")
+	output, renderedAt, err := renderCache.Load(renderKey, maxStaleness, time.Now())
+	if err != nil {
+		slog.Error("Render would remove replicas and there is no last good render to keep them from", "replicas", removals, "err", err)
+		return nil, fmt.Errorf("%w: render would remove %s: %w", k8s.ErrRemovalDenied, strings.Join(removals, ", "), err)
+	}
+	replicas, err := sources.ParseSecrets(bytes.NewReader(output))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range removals {
+		i := slices.IndexFunc(replicas, func(replica corev1.Secret) bool {
+			return replica.Name == name
+		})
+		if i < 0 {
+			slog.Error("Render would remove a replica that is not in the last good render", "replica", name)
+			return nil, fmt.Errorf("%w: render would remove %s, it is not in the last good render", k8s.ErrRemovalDenied, name)
+		}
+		replica, err := k8s.KeepReplica(replicas[i], renderedAt, maxStaleness, time.Now())
+		if err != nil {
+			slog.Error("Render would remove a replica and its copy can't be kept", "replica", name, "err", err)
+			return nil, err
+		}
+		client.Kept(replica)
+		kept = append(kept, replica)
+	}
+	slog.Warn(
+		"Render would remove replicas, keeping them from the last good render",
+		"replicas", removals,
+		"renderedAt", renderedAt.UTC().Format(time.RFC3339),
+	)
+	return kept, nil
+}
+
+// newRenderCache returns nil if stale-while-error is not enabled
+func newRenderCache() (*cache.RenderCache, error) {
+	keyFile := viper.GetString("render-cache-key-file")
+	if keyFile == "" {
+		return nil, nil
+	}
+	renderCache, err := cache.NewRenderCache(viper.GetString("render-cache-dir"), keyFile)
+	if err != nil {
+		slog.Error("Failed to open render cache", "err", err)
+		return nil, err
+	}
+	return renderCache, nil
+}
+
+// serveLastGoodRender writes the last good render instead of failing, returns renderErr if there is none
+func serveLastGoodRender(renderCache *cache.RenderCache, renderKey string, renderErr error) error {
+	output, renderedAt, err := renderCache.Load(renderKey, viper.GetDuration("render-cache-max-staleness"), time.Now())
+	if err != nil {
+		slog.Error("Failed to get secrets and no last good render to serve", "err", renderErr, "cacheErr", err)
+		return renderErr
+	}
+
+	slog.Warn(
+		"Failed to get secrets, serving last good render",
+		"err", renderErr,
+		"renderedAt", renderedAt.UTC().Format(time.RFC3339),
+		"age", time.Since(renderedAt).Round(time.Second).String(),
+	)
+	_, err = os.Stdout.Write(output)
+	return err
+}
@@ -0,0 +1,59 @@
+package serve
+
+import (
+	"log/slog"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/cache"
+	"github.com/plumber-cd/argocd-cmp-replicator/cmd/common"
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/plumber-cd/argocd-cmp-replicator/metrics"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	Cmd.Flags().String("cache-socket", cache.DefaultSocket, "Unix socket to serve the secrets cache on")
+	Cmd.Flags().Duration("resync", 10*time.Minute, "How often informers resync the cache")
+	Cmd.Flags().String("metrics-address", ":9102", "Address to serve Prometheus metrics on, renders report to the server over the cache socket - empty disables metrics")
+}
+
+// Cmd will keep labeled secrets cached and serve them to the secrets command
+var Cmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve labeled secrets from an informer cache over a Unix socket",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		client, err := k8s.New(common.ClientOptions())
+		if err != nil {
+			slog.Error("Failed to create k8s client", "err", err)
+			return err
+		}
+
+		server := cache.NewServer(client.Interface, viper.GetDuration("resync"))
+		if address := viper.GetString("metrics-address"); address != "" {
+			server.Metrics = metrics.New()
+			go func() {
+				if err := server.Metrics.Serve(ctx, address); err != nil {
+					slog.Error("Failed to serve metrics", "err", err)
+					stop()
+				}
+			}()
+		}
+		if err := server.Start(ctx); err != nil {
+			slog.Error("Failed to start informers", "err", err)
+			return err
+		}
+
+		if err := server.Serve(ctx, viper.GetString("cache-socket")); err != nil {
+			slog.Error("Failed to serve secrets cache", "err", err)
+			return err
+		}
+
+		return nil
+	},
+}
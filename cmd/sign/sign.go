@@ -0,0 +1,79 @@
+package sign
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/grants"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func init() {
+	Cmd.Flags().String("private-key", "", "Path to PEM encoded ed25519 private key")
+	Cmd.Flags().StringP("filename", "f", "", "Secret manifest to read the grant from, instead of --namespace, --name and annotation flags")
+	Cmd.Flags().String("namespace", "", "Namespace of the secret")
+	Cmd.Flags().String("name", "", "Name of the secret")
+	Cmd.Flags().String("allowed-namespaces", "", "Value of the allowed-namespaces annotation")
+	Cmd.Flags().String("replicated-name", "", "Value of the replicated-name annotation")
+	Cmd.Flags().String("not-before", "", "Value of the not-before annotation")
+	Cmd.Flags().String("not-after", "", "Value of the not-after annotation")
+}
+
+// Cmd will print the grant signature annotation
+var Cmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Sign a replication grant and print the annotation",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		privateKeyPath := viper.GetString("private-key")
+		if privateKeyPath == "" {
+			return errors.New("--private-key is required")
+		}
+		key, err := grants.LoadPrivateKey(privateKeyPath)
+		if err != nil {
+			slog.Error("Failed to load private key", "err", err)
+			return err
+		}
+
+		grant := grants.Grant{
+			Namespace:         viper.GetString("namespace"),
+			Name:              viper.GetString("name"),
+			AllowedNamespaces: viper.GetString("allowed-namespaces"),
+			ReplicatedName:    viper.GetString("replicated-name"),
+			NotBefore:         viper.GetString("not-before"),
+			NotAfter:          viper.GetString("not-after"),
+		}
+		if filename := viper.GetString("filename"); filename != "" {
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			secret := corev1.Secret{}
+			if err := yaml.Unmarshal(data, &secret); err != nil {
+				return err
+			}
+			grant = grants.FromSecret(secret)
+		}
+
+		if grant.Namespace == "" || grant.Name == "" {
+			return errors.New("Secret namespace and name must be set")
+		}
+
+		slog.Debug("Signing grant", "grant", string(grant.Payload()))
+
+		out, err := yaml.Marshal(map[string]string{
+			types.ReplicatorAnnotationGrantSignature: grants.Sign(grant, key),
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(string(out))
+		return nil
+	},
+}
@@ -0,0 +1,49 @@
+package sync
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/cmd/common"
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"sigs.k8s.io/yaml"
+)
+
+func init() {
+	Cmd.Flags().Bool("dry-run", false, "Only print changes that would be made")
+	common.AddTimeoutFlag(Cmd.Flags())
+}
+
+// Cmd will set index labels on all replicable secrets in the cluster
+var Cmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Set index labels on replicable secrets to match their allowed namespaces",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := common.WithTimeout(cmd.Context())
+		defer cancel()
+
+		client, err := k8s.New(common.ClientOptions())
+		if err != nil {
+			slog.Error("Failed to create k8s client", "err", err)
+			return err
+		}
+
+		results, err := client.Sync(ctx, viper.GetBool("dry-run"))
+		if err != nil {
+			slog.Error("Failed to sync secret index", "err", err)
+			return err
+		}
+
+		slog.Info("Sync finished", "changed", len(results), "dryRun", viper.GetBool("dry-run"))
+
+		out, err := yaml.Marshal(results)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(string(out))
+		return nil
+	},
+}
@@ -0,0 +1,141 @@
+package grants
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var ErrUnsigned = errors.New("grant is not signed")
+var ErrUntrusted = errors.New("grant signature does not match any trusted key")
+
+// Grant is what the secret owner allows - it is bound to a specific secret so the signature can't be moved to another one.
+type Grant struct {
+	Namespace         string `json:"namespace"`
+	Name              string `json:"name"`
+	AllowedNamespaces string `json:"allowedNamespaces"`
+	ReplicatedName    string `json:"replicatedName"`
+	NotBefore         string `json:"notBefore,omitempty"`
+	NotAfter          string `json:"notAfter,omitempty"`
+}
+
+func FromSecret(secret corev1.Secret) Grant {
+	return Grant{
+		Namespace:         secret.Namespace,
+		Name:              secret.Name,
+		AllowedNamespaces: secret.Annotations[types.ReplicatorAnnotationAllowedNamespaces],
+		ReplicatedName:    secret.Annotations[types.ReplicatorAnnotationReplicatedName],
+		NotBefore:         secret.Annotations[types.ReplicatorAnnotationNotBefore],
+		NotAfter:          secret.Annotations[types.ReplicatorAnnotationNotAfter],
+	}
+}
+
+// IsEmpty is true when the secret does not grant anything beyond implicit replication to its own namespace.
+func (g Grant) IsEmpty() bool {
+	return (g.AllowedNamespaces == "" || g.AllowedNamespaces == "-") && g.ReplicatedName == ""
+}
+
+// Payload is the message that gets signed, struct fields are always marshalled in the same order.
+func (g Grant) Payload() []byte {
+	payload, err := json.Marshal(g)
+	if err != nil {
+		panic(err)
+	}
+	return payload
+}
+
+func Sign(grant Grant, key ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, grant.Payload()))
+}
+
+func Verify(grant Grant, signature string, keys []ed25519.PublicKey) error {
+	if signature == "" {
+		return ErrUnsigned
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode grant signature: %w", err)
+	}
+
+	payload := grant.Payload()
+	for _, key := range keys {
+		if ed25519.Verify(key, payload, decoded) {
+			return nil
+		}
+	}
+
+	return ErrUntrusted
+}
+
+// VerifySecret checks the signature annotation on the secret.
+func VerifySecret(secret corev1.Secret, keys []ed25519.PublicKey) error {
+	return Verify(FromSecret(secret), secret.Annotations[types.ReplicatorAnnotationGrantSignature], keys)
+}
+
+// LoadPublicKeys reads PEM encoded ed25519 public keys, each file may contain more than one key.
+func LoadPublicKeys(paths []string) ([]ed25519.PublicKey, error) {
+	keys := []ed25519.PublicKey{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse public key in %s: %w", path, err)
+			}
+			edKey, ok := key.(ed25519.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("public key in %s is not ed25519", path)
+			}
+			keys = append(keys, edKey)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found in %v", paths)
+	}
+
+	return keys, nil
+}
+
+// LoadPrivateKey reads PEM encoded PKCS #8 ed25519 private key, i.e. from `openssl genpkey -algorithm ed25519`.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key in %s: %w", path, err)
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key in %s is not ed25519", path)
+	}
+
+	return edKey, nil
+}
@@ -0,0 +1,110 @@
+package grants
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestVerifySecret(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	newSecret := func() corev1.Secret {
+		return corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "labeled-secret",
+				Namespace: "some-other-namespace",
+				Annotations: map[string]string{
+					types.ReplicatorAnnotationAllowedNamespaces: "*",
+				},
+			},
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		secret := newSecret()
+		secret.Annotations[types.ReplicatorAnnotationGrantSignature] = Sign(FromSecret(secret), private)
+
+		require.NoError(t, VerifySecret(secret, []ed25519.PublicKey{otherPublic, public}))
+	})
+	t.Run("unsigned", func(t *testing.T) {
+		require.ErrorIs(t, VerifySecret(newSecret(), []ed25519.PublicKey{public}), ErrUnsigned)
+	})
+	t.Run("untrusted-key", func(t *testing.T) {
+		secret := newSecret()
+		secret.Annotations[types.ReplicatorAnnotationGrantSignature] = Sign(FromSecret(secret), private)
+
+		require.ErrorIs(t, VerifySecret(secret, []ed25519.PublicKey{otherPublic}), ErrUntrusted)
+	})
+	t.Run("tampered-grant", func(t *testing.T) {
+		secret := newSecret()
+		secret.Annotations[types.ReplicatorAnnotationAllowedNamespaces] = "my-test-namespace"
+		secret.Annotations[types.ReplicatorAnnotationGrantSignature] = Sign(FromSecret(secret), private)
+		secret.Annotations[types.ReplicatorAnnotationAllowedNamespaces] = "*"
+
+		require.ErrorIs(t, VerifySecret(secret, []ed25519.PublicKey{public}), ErrUntrusted)
+	})
+	t.Run("moved-to-another-secret", func(t *testing.T) {
+		secret := newSecret()
+		signature := Sign(FromSecret(secret), private)
+		secret.Name = "another-secret"
+		secret.Annotations[types.ReplicatorAnnotationGrantSignature] = signature
+
+		require.ErrorIs(t, VerifySecret(secret, []ed25519.PublicKey{public}), ErrUntrusted)
+	})
+	t.Run("garbage", func(t *testing.T) {
+		secret := newSecret()
+		secret.Annotations[types.ReplicatorAnnotationGrantSignature] = "not base64!"
+
+		require.Error(t, VerifySecret(secret, []ed25519.PublicKey{public}))
+	})
+}
+
+func TestLoadKeys(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+	privatePath := filepath.Join(dir, "private.pem")
+	require.NoError(t, os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600))
+
+	bundle := []byte{}
+	for _, key := range []ed25519.PublicKey{public, otherPublic} {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		require.NoError(t, err)
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	publicPath := filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(publicPath, bundle, 0600))
+
+	loadedPrivate, err := LoadPrivateKey(privatePath)
+	require.NoError(t, err)
+	require.True(t, private.Equal(loadedPrivate))
+
+	loadedPublic, err := LoadPublicKeys([]string{publicPath})
+	require.NoError(t, err)
+	require.Len(t, loadedPublic, 2)
+	require.True(t, public.Equal(loadedPublic[0]))
+	require.True(t, otherPublic.Equal(loadedPublic[1]))
+
+	_, err = LoadPublicKeys([]string{privatePath})
+	require.Error(t, err)
+}
@@ -0,0 +1,130 @@
+package k8s
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+)
+
+// ArgoCD annotations set on replicas from ArgoCDOptions
+const (
+	AnnotationSyncWave       = "argocd.argoproj.io/sync-wave"
+	AnnotationSyncOptions    = "argocd.argoproj.io/sync-options"
+	AnnotationCompareOptions = "argocd.argoproj.io/compare-options"
+)
+
+// knownSyncOptions are sync options ArgoCD reads from resource annotations, with their values
+var knownSyncOptions = map[string][]string{
+	"Prune":                       {"false", "confirm"},
+	"Delete":                      {"false", "confirm"},
+	"Validate":                    {"true", "false"},
+	"SkipDryRunOnMissingResource": {"true"},
+	"PruneLast":                   {"true"},
+	"Replace":                     {"true", "false"},
+	"Force":                       {"true"},
+	"ServerSideApply":             {"true", "false"},
+}
+
+// knownCompareOptions are compare options ArgoCD reads from resource annotations, with their values.
+// An empty value is an option without one.
+var knownCompareOptions = map[string][]string{
+	"IgnoreExtraneous":       {""},
+	"ServerSideDiff":         {"true", "false"},
+	"IncludeMutationWebhook": {"true", "false"},
+}
+
+// ArgoCDOptions are ArgoCD annotations for replicas, empty fields are not set
+type ArgoCDOptions struct {
+	SyncWave       string
+	SyncOptions    string
+	CompareOptions string
+}
+
+// ParseArgoCDOptions validates options against the ones ArgoCD knows, and returns them normalized
+func ParseArgoCDOptions(syncWave, syncOptions, compareOptions string) (ArgoCDOptions, error) {
+	options := ArgoCDOptions{}
+	if syncWave = strings.TrimSpace(syncWave); syncWave != "" {
+		wave, err := strconv.Atoi(syncWave)
+		if err != nil {
+			return ArgoCDOptions{}, fmt.Errorf("sync wave %q is not an integer", syncWave)
+		}
+		options.SyncWave = strconv.Itoa(wave)
+	}
+
+	var err error
+	if options.SyncOptions, err = parseOptionList(syncOptions, knownSyncOptions); err != nil {
+		return ArgoCDOptions{}, fmt.Errorf("sync options: %w", err)
+	}
+	if options.CompareOptions, err = parseOptionList(compareOptions, knownCompareOptions); err != nil {
+		return ArgoCDOptions{}, fmt.Errorf("compare options: %w", err)
+	}
+	return options, nil
+}
+
+// parseOptionList checks comma separated Key=value options, ArgoCD ignores ones it does not know so typos would pass silently
+func parseOptionList(list string, known map[string][]string) (string, error) {
+	options := []string{}
+	seen := map[string]string{}
+	for _, option := range strings.Split(list, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(option, "=")
+		values, ok := known[key]
+		if !ok {
+			return "", fmt.Errorf("unknown option %s", key)
+		}
+		if !slices.Contains(values, value) {
+			return "", fmt.Errorf("unknown value %q of %s, expected one of %s", value, key, strings.Join(values, ", "))
+		}
+		if previous, ok := seen[key]; ok {
+			if previous != value {
+				return "", fmt.Errorf("conflicting values of %s", key)
+			}
+			continue
+		}
+		seen[key] = value
+		options = append(options, option)
+	}
+	return strings.Join(options, ","), nil
+}
+
+// Override returns these options with fields set in other replacing them
+func (o ArgoCDOptions) Override(other ArgoCDOptions) ArgoCDOptions {
+	if other.SyncWave != "" {
+		o.SyncWave = other.SyncWave
+	}
+	if other.SyncOptions != "" {
+		o.SyncOptions = other.SyncOptions
+	}
+	if other.CompareOptions != "" {
+		o.CompareOptions = other.CompareOptions
+	}
+	return o
+}
+
+func (o ArgoCDOptions) annotate(annotations map[string]string) {
+	if o.SyncWave != "" {
+		annotations[AnnotationSyncWave] = o.SyncWave
+	}
+	if o.SyncOptions != "" {
+		annotations[AnnotationSyncOptions] = o.SyncOptions
+	}
+	if o.CompareOptions != "" {
+		annotations[AnnotationCompareOptions] = o.CompareOptions
+	}
+}
+
+// argoCDOptions reads options the source sets for its replicas.
+// Unlike ValidateSecret, invalid options always fail the render regardless of Policy.InvalidSecrets.
+func argoCDOptions(annotations map[string]string) (ArgoCDOptions, error) {
+	return ParseArgoCDOptions(
+		annotations[types.ReplicatorAnnotationSyncWave],
+		annotations[types.ReplicatorAnnotationSyncOptions],
+		annotations[types.ReplicatorAnnotationCompareOptions],
+	)
+}
@@ -0,0 +1,114 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseArgoCDOptions(t *testing.T) {
+	tests := []struct {
+		name           string
+		syncWave       string
+		syncOptions    string
+		compareOptions string
+		expected       ArgoCDOptions
+		valid          bool
+	}{
+		{"empty", "", "", "", ArgoCDOptions{}, true},
+		{"sync-wave", " -1 ", "", "", ArgoCDOptions{SyncWave: "-1"}, true},
+		{"sync-wave-not-integer", "first", "", "", ArgoCDOptions{}, false},
+		{"sync-options", "", "Prune=false, ServerSideApply=true,", "", ArgoCDOptions{SyncOptions: "Prune=false,ServerSideApply=true"}, true},
+		{"sync-options-duplicate", "", "Prune=false,Prune=false", "", ArgoCDOptions{SyncOptions: "Prune=false"}, true},
+		{"sync-options-conflict", "", "Prune=false,Prune=confirm", "", ArgoCDOptions{}, false},
+		{"sync-options-unknown", "", "Prnue=false", "", ArgoCDOptions{}, false},
+		{"sync-options-unknown-value", "", "Prune=no", "", ArgoCDOptions{}, false},
+		{"sync-options-no-value", "", "Prune", "", ArgoCDOptions{}, false},
+		{"compare-options", "", "", "IgnoreExtraneous,ServerSideDiff=true", ArgoCDOptions{CompareOptions: "IgnoreExtraneous,ServerSideDiff=true"}, true},
+		{"compare-options-unexpected-value", "", "", "IgnoreExtraneous=true", ArgoCDOptions{}, false},
+		{"compare-options-sync-option", "", "", "Prune=false", ArgoCDOptions{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, err := ParseArgoCDOptions(test.syncWave, test.syncOptions, test.compareOptions)
+			if test.valid {
+				require.NoError(t, err)
+				require.Equal(t, test.expected, options)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestWriteSecretListManifestsWithArgoCDOptions(t *testing.T) {
+	client := Client{
+		Interface: testClient.NewSimpleClientset(
+			newLabeledSecret("defaults", "some-other-namespace", map[string]string{
+				types.ReplicatorAnnotationAllowedNamespaces: "*",
+			}),
+			newLabeledSecret("overrides", "some-other-namespace", map[string]string{
+				types.ReplicatorAnnotationAllowedNamespaces: "*",
+				types.ReplicatorAnnotationSyncWave:          "-5",
+				types.ReplicatorAnnotationSyncOptions:       "Delete=false",
+			}),
+			newLabeledSecret("typo", "some-other-namespace", map[string]string{
+				types.ReplicatorAnnotationAllowedNamespaces: "*",
+				types.ReplicatorAnnotationSyncOptions:       "Prune=flase",
+			}),
+		),
+		ArgoCDOptions: ArgoCDOptions{
+			SyncWave:       "-1",
+			SyncOptions:    "Prune=false",
+			CompareOptions: "IgnoreExtraneous",
+		},
+	}
+
+	// Invalid options fail the render whatever the invalid secrets policy is
+	for _, invalidSecrets := range []string{"", InvalidSecretsWarn, InvalidSecretsSkip, InvalidSecretsFail} {
+		client.Policy.InvalidSecrets = invalidSecrets
+		_, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.ErrorIs(t, err, ErrPolicyDenied, invalidSecrets)
+	}
+
+	findings, err := client.Lint(context.TODO(), LintOptions{})
+	require.NoError(t, err)
+	require.Len(t, findings, 1)
+	require.Equal(t, "typo", findings[0].Name)
+	require.Equal(t, LintReasonInvalidArgoCDOptions, findings[0].Reason)
+
+	require.NoError(t, client.CoreV1().Secrets("some-other-namespace").Delete(context.TODO(), "typo", metav1.DeleteOptions{}))
+	secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+	require.NoError(t, err)
+	require.Len(t, secrets.Items, 2)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, client.WriteSecretListManifests(context.TODO(), "my-test-namespace", secrets, buf))
+
+	replicas := map[string]map[string]string{}
+	for _, doc := range bytes.Split(buf.Bytes(), []byte("---\n")) {
+		replica := corev1.Secret{}
+		require.NoError(t, yaml.Unmarshal(doc, &replica))
+		replicas[replica.Name] = replica.Annotations
+	}
+
+	require.Equal(t, "-1", replicas["defaults-replicated-from-some-other-namespace"][AnnotationSyncWave])
+	require.Equal(t, "Prune=false", replicas["defaults-replicated-from-some-other-namespace"][AnnotationSyncOptions])
+	require.Equal(t, "IgnoreExtraneous", replicas["defaults-replicated-from-some-other-namespace"][AnnotationCompareOptions])
+
+	require.Equal(t, "-5", replicas["overrides-replicated-from-some-other-namespace"][AnnotationSyncWave])
+	require.Equal(t, "Delete=false", replicas["overrides-replicated-from-some-other-namespace"][AnnotationSyncOptions])
+	require.Equal(t, "IgnoreExtraneous", replicas["overrides-replicated-from-some-other-namespace"][AnnotationCompareOptions])
+	require.NotContains(t, replicas["overrides-replicated-from-some-other-namespace"], types.ReplicatorAnnotationSyncWave)
+	require.NotContains(t, replicas["overrides-replicated-from-some-other-namespace"], types.ReplicatorAnnotationSyncOptions)
+}
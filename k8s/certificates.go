@@ -0,0 +1,159 @@
+package k8s
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// certificateKeys are the secret keys that are expected to contain PEM certificates
+var certificateKeys = []string{
+	corev1.TLSCertKey,
+	corev1.ServiceAccountRootCAKey,
+}
+
+// parseCertificates returns all certificates found in the PEM data, non-certificate blocks are ignored.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// checkCertificates returns the earliest notAfter among all certificates in the secret, or zero time if there are none.
+// If the earliest certificate expires within the warning period it is logged, and expired certificate is an error if refuseExpired is set.
+func (p Policy) checkCertificates(secret corev1.Secret) (time.Time, error) {
+	earliest, certsByKey := earliestCertificate(secret)
+	if earliest == nil {
+		return time.Time{}, nil
+	}
+	notAfter := earliest.NotAfter
+
+	checkChain(secret, certsByKey[corev1.TLSCertKey], certsByKey[corev1.ServiceAccountRootCAKey])
+
+	t := now()
+	if !t.Before(notAfter) {
+		if p.RefuseExpiredCerts {
+			return notAfter, expiredCertificateError(secret, earliest)
+		}
+		slog.Warn(
+			"Secret has expired certificate",
+			"name", secret.Name,
+			"namespace", secret.Namespace,
+			"subject", earliest.Subject.String(),
+			"notAfter", notAfter,
+		)
+	} else if notAfter.Sub(t) <= p.CertExpiryWarning {
+		slog.Warn(
+			"Secret has certificate that is about to expire",
+			"name", secret.Name,
+			"namespace", secret.Namespace,
+			"subject", earliest.Subject.String(),
+			"notAfter", notAfter,
+		)
+	}
+
+	return notAfter, nil
+}
+
+// refuseExpiredCertificates is the same check as checkCertificates without logging, to deny the secret before it is written
+func (p Policy) refuseExpiredCertificates(secret corev1.Secret) error {
+	if !p.RefuseExpiredCerts {
+		return nil
+	}
+	earliest, _ := earliestCertificate(secret)
+	if earliest == nil || now().Before(earliest.NotAfter) {
+		return nil
+	}
+	return expiredCertificateError(secret, earliest)
+}
+
+func expiredCertificateError(secret corev1.Secret, cert *x509.Certificate) error {
+	return fmt.Errorf(
+		"secret %s/%s has expired certificate %q (notAfter %s)",
+		secret.Namespace, secret.Name, cert.Subject.String(), cert.NotAfter.Format(time.RFC3339),
+	)
+}
+
+// earliestCertificate returns the certificate that expires first, if any, and all certificates by key
+func earliestCertificate(secret corev1.Secret) (*x509.Certificate, map[string][]*x509.Certificate) {
+	var earliest *x509.Certificate
+	certsByKey := map[string][]*x509.Certificate{}
+	for _, key := range certificateKeys {
+		if len(secret.Data[key]) == 0 {
+			continue
+		}
+		certs, err := parseCertificates(secret.Data[key])
+		if err != nil {
+			slog.Debug(
+				"Failed to parse certificates",
+				"name", secret.Name,
+				"namespace", secret.Namespace,
+				"key", key,
+				"err", err,
+			)
+			continue
+		}
+		certsByKey[key] = certs
+		for _, cert := range certs {
+			if earliest == nil || cert.NotAfter.Before(earliest.NotAfter) {
+				earliest = cert
+			}
+		}
+	}
+	return earliest, certsByKey
+}
+
+// checkChain logs problems with the certificate chain, it never fails as the chain may be completed by the consumer.
+func checkChain(secret corev1.Secret, chain, cas []*x509.Certificate) {
+	if len(chain) == 0 {
+		return
+	}
+
+	for i := 0; i < len(chain)-1; i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			slog.Debug(
+				"Certificate is not signed by the next one in the chain",
+				"name", secret.Name,
+				"namespace", secret.Namespace,
+				"subject", chain[i].Subject.String(),
+				"next", chain[i+1].Subject.String(),
+				"err", err,
+			)
+		}
+	}
+
+	last := chain[len(chain)-1]
+	if last.CheckSignatureFrom(last) == nil {
+		return
+	}
+	for _, ca := range cas {
+		if last.CheckSignatureFrom(ca) == nil {
+			return
+		}
+	}
+	slog.Debug(
+		"Certificate chain is incomplete - missing intermediate or root",
+		"name", secret.Name,
+		"namespace", secret.Namespace,
+		"subject", last.Subject.String(),
+		"issuer", last.Issuer.String(),
+	)
+}
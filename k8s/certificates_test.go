@@ -0,0 +1,144 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWriteSecretListManifestsWithCertificates(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	}
+	t.Cleanup(func() {
+		now = time.Now
+	})
+
+	leaf, key := newTestCertificate(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	ca, _ := newTestCertificate(t, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC))
+	expired, _ := newTestCertificate(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+
+	newSecrets := func(cert []byte) *corev1.SecretList {
+		return &corev1.SecretList{
+			Items: []corev1.Secret{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "some-tls-secret",
+						Namespace: "some-namespace",
+					},
+					Type: corev1.SecretTypeTLS,
+					Data: map[string][]byte{
+						corev1.TLSCertKey:              cert,
+						corev1.TLSPrivateKeyKey:        key,
+						corev1.ServiceAccountRootCAKey: ca,
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("earliest-not-after", func(t *testing.T) {
+		client := Client{
+			Policy: Policy{
+				CertExpiryWarning:  24 * time.Hour,
+				RefuseExpiredCerts: true,
+			},
+		}
+
+		buf := bytes.NewBufferString("")
+		require.NoError(t, client.WriteSecretListManifests(context.TODO(), "my-test-namespace", newSecrets(leaf), buf))
+
+		secret := corev1.Secret{}
+		require.NoError(t, yaml.Unmarshal(buf.Bytes(), &secret))
+		require.Equal(t, "2024-04-01T00:00:00Z", secret.Annotations[types.ReplicatorAnnotationCertNotAfter])
+	})
+	t.Run("refuse-expired", func(t *testing.T) {
+		client := Client{
+			Policy: Policy{
+				RefuseExpiredCerts: true,
+			},
+		}
+
+		buf := bytes.NewBufferString("")
+		err := client.WriteSecretListManifests(context.TODO(), "my-test-namespace", newSecrets(expired), buf)
+		require.ErrorContains(t, err, "some-namespace/some-tls-secret has expired certificate")
+	})
+	t.Run("refuse-expired-decision", func(t *testing.T) {
+		secret := newSecrets(expired).Items[0]
+		secret.Labels = map[string]string{
+			types.ReplicatorLabel: "true",
+		}
+		secret.Annotations = map[string]string{
+			types.ReplicatorAnnotationAllowedNamespaces: "*",
+		}
+		decisions := []Decision{}
+		client := Client{
+			Interface: testClient.NewSimpleClientset(&secret),
+			Policy: Policy{
+				RefuseExpiredCerts: true,
+			},
+			OnDecision: func(_ corev1.Secret, decision Decision) {
+				decisions = append(decisions, decision)
+			},
+		}
+
+		// Never recorded as replicated, the render fails
+		_, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.ErrorIs(t, err, ErrPolicyDenied)
+		require.ErrorContains(t, err, "some-namespace/some-tls-secret has expired certificate")
+		require.Len(t, decisions, 1)
+		require.Equal(t, DecisionDenied, decisions[0].Decision)
+		require.Equal(t, ReasonCertExpired, decisions[0].Reason)
+	})
+	t.Run("allow-expired", func(t *testing.T) {
+		client := Client{}
+
+		buf := bytes.NewBufferString("")
+		require.NoError(t, client.WriteSecretListManifests(context.TODO(), "my-test-namespace", newSecrets(expired), buf))
+
+		secret := corev1.Secret{}
+		require.NoError(t, yaml.Unmarshal(buf.Bytes(), &secret))
+		require.Equal(t, "2024-02-01T00:00:00Z", secret.Annotations[types.ReplicatorAnnotationCertNotAfter])
+	})
+	t.Run("no-certificates", func(t *testing.T) {
+		client := Client{
+			Policy: Policy{
+				RefuseExpiredCerts: true,
+			},
+		}
+
+		secrets := &corev1.SecretList{
+			Items: []corev1.Secret{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "some-secret",
+						Namespace: "some-namespace",
+						Annotations: map[string]string{
+							types.ReplicatorAnnotationCertNotAfter: "2000-01-01T00:00:00Z",
+						},
+					},
+					Data: map[string][]byte{
+						corev1.ServiceAccountRootCAKey: []byte("not a certificate"),
+					},
+				},
+			},
+		}
+
+		buf := bytes.NewBufferString("")
+		require.NoError(t, client.WriteSecretListManifests(context.TODO(), "my-test-namespace", secrets, buf))
+
+		secret := corev1.Secret{}
+		require.NoError(t, yaml.Unmarshal(buf.Bytes(), &secret))
+		require.NotContains(t, secret.Annotations, types.ReplicatorAnnotationCertNotAfter)
+	})
+}
@@ -1,11 +1,19 @@
 package k8s
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
+	argocdclientset "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -16,25 +24,74 @@ import (
 
 type Client struct {
 	kubernetes.Interface
+	// ArgoCD reads Applications from the cluster ArgoCD runs in, only set by New
+	ArgoCD argocdclientset.Interface
+	Policy Policy
+	// Sources to find secrets in, defaults to the cluster this client is connected to
+	Sources []SecretSource
+	// ClusterName is set for clients of ArgoCD registered clusters and recorded on replicas
+	ClusterName string
+	// Options the client was created with, rate limits and retries are reused for clients of other clusters
+	Options ClientOptions
+	// Stats if set, collects skipped secrets and certificate expiry during the render
+	Stats *Stats
+	// ArgoCDOptions are set on all replicas, options set on sources replace them
+	ArgoCDOptions ArgoCDOptions
+	// OnDecision if set, is called for every candidate secret considered during the render.
+	// Candidates are passed without data, once the listing they were found in is over.
+	OnDecision func(corev1.Secret, Decision)
 }
 
-func New() (*Client, error) {
-	_, clientset, err := GetClient()
+// ClientOptions allows to use a dedicated token for the plugin instead of the repo server service account.
+// If TokenFile is not set, in-cluster config and then kubeconfig are used as usual.
+type ClientOptions struct {
+	// Host of the API server, defaults to KUBERNETES_SERVICE_HOST:KUBERNETES_SERVICE_PORT
+	Host string
+	// TokenFile is a path to the projected service account token, it is re-read by client-go as it rotates
+	TokenFile string
+	// CAFile is a path to the CA bundle to verify the API server
+	CAFile string
+	// Audience if set, the token must be issued for it
+	Audience string
+	// QPS and Burst limit requests to the API server, client-go defaults are used if not set
+	QPS   float32
+	Burst int
+	// Retry for API calls that fail with retryable errors
+	Retry Retry
+	// PageSize is how many secrets to list at once, DefaultPageSize if 0 and no pagination if negative
+	PageSize int64
+	// UseIndex if set, only secrets with index labels for the destination are listed, see Sync
+	UseIndex bool
+}
+
+func New(options ClientOptions) (*Client, error) {
+	config, clientset, err := GetClient(options)
+	if err != nil {
+		return nil, err
+	}
+	argocd, err := argocdclientset.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Client{
-		clientset,
+		Interface: clientset,
+		ArgoCD:    argocd,
+		Options:   options,
 	}, nil
 }
 
-func GetClient() (*rest.Config, kubernetes.Interface, error) {
+func GetClient(options ClientOptions) (*rest.Config, kubernetes.Interface, error) {
 	var config *rest.Config
 	var err error
 
-	// Try to use in-cluster config
-	if config, err = rest.InClusterConfig(); err != nil {
+	if options.TokenFile != "" {
+		slog.Debug("Using dedicated token file", "tokenFile", options.TokenFile, "caFile", options.CAFile)
+		if config, err = tokenFileConfig(options); err != nil {
+			return nil, nil, err
+		}
+	} else if config, err = rest.InClusterConfig(); err != nil {
+		// Try to use in-cluster config
 		slog.Debug("We are not in cluster - is this a local environment?")
 		// If in-cluster config fails, fallback to KUBECONFIG or default kubeconfig file
 		kubeconfigPath := ""
@@ -56,6 +113,8 @@ func GetClient() (*rest.Config, kubernetes.Interface, error) {
 		}
 	}
 
+	options.applyRateLimits(config)
+
 	// Create the clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -64,3 +123,80 @@ func GetClient() (*rest.Config, kubernetes.Interface, error) {
 
 	return config, clientset, nil
 }
+
+func (options ClientOptions) applyRateLimits(config *rest.Config) {
+	if options.QPS > 0 {
+		config.QPS = options.QPS
+	}
+	if options.Burst > 0 {
+		config.Burst = options.Burst
+	}
+}
+
+func tokenFileConfig(options ClientOptions) (*rest.Config, error) {
+	host := options.Host
+	if host == "" {
+		serviceHost, servicePort := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if serviceHost == "" || servicePort == "" {
+			return nil, errors.New("API server host is not set and KUBERNETES_SERVICE_HOST/KUBERNETES_SERVICE_PORT are not defined")
+		}
+		host = "https://" + net.JoinHostPort(serviceHost, servicePort)
+	}
+
+	token, err := os.ReadFile(options.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Audience != "" {
+		audiences, err := tokenAudiences(strings.TrimSpace(string(token)))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read audience from %s: %w", options.TokenFile, err)
+		}
+		if !slices.Contains(audiences, options.Audience) {
+			return nil, fmt.Errorf("Token %s is not issued for audience %q: %v", options.TokenFile, options.Audience, audiences)
+		}
+	}
+
+	// BearerTokenFile (unlike BearerToken) makes client-go to periodically re-read the file
+	return &rest.Config{
+		Host:            host,
+		BearerTokenFile: options.TokenFile,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAFile: options.CAFile,
+		},
+	}, nil
+}
+
+// tokenAudiences reads aud claim from the JWT without verifying it - the API server will do that.
+func tokenAudiences(token string) ([]string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	claims := struct {
+		Audience json.RawMessage `json:"aud"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+
+	audiences := []string{}
+	if len(claims.Audience) == 0 {
+		return audiences, nil
+	}
+	if err := json.Unmarshal(claims.Audience, &audiences); err == nil {
+		return audiences, nil
+	}
+	audience := ""
+	if err := json.Unmarshal(claims.Audience, &audience); err != nil {
+		return nil, err
+	}
+	return append(audiences, audience), nil
+}
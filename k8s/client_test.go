@@ -0,0 +1,77 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeToken(t *testing.T, claims string) string {
+	token := "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte(token), 0600))
+	return path
+}
+
+func TestTokenFileConfig(t *testing.T) {
+	t.Run("explicit-host", func(t *testing.T) {
+		tokenFile := writeToken(t, `{"aud":["argocd-cmp-replicator"]}`)
+
+		config, err := tokenFileConfig(ClientOptions{
+			Host:      "https://kubernetes.example.com:6443",
+			TokenFile: tokenFile,
+			CAFile:    "/var/run/secrets/argocd-cmp-replicator/ca.crt",
+			Audience:  "argocd-cmp-replicator",
+		})
+		require.NoError(t, err)
+		require.Equal(t, "https://kubernetes.example.com:6443", config.Host)
+		require.Equal(t, tokenFile, config.BearerTokenFile)
+		require.Empty(t, config.BearerToken)
+		require.Equal(t, "/var/run/secrets/argocd-cmp-replicator/ca.crt", config.TLSClientConfig.CAFile)
+	})
+	t.Run("host-from-env", func(t *testing.T) {
+		t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+		t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+
+		config, err := tokenFileConfig(ClientOptions{
+			TokenFile: writeToken(t, `{}`),
+		})
+		require.NoError(t, err)
+		require.Equal(t, "https://10.0.0.1:443", config.Host)
+	})
+	t.Run("no-host", func(t *testing.T) {
+		t.Setenv("KUBERNETES_SERVICE_HOST", "")
+		t.Setenv("KUBERNETES_SERVICE_PORT", "")
+
+		_, err := tokenFileConfig(ClientOptions{
+			TokenFile: writeToken(t, `{}`),
+		})
+		require.Error(t, err)
+	})
+	t.Run("single-audience", func(t *testing.T) {
+		_, err := tokenFileConfig(ClientOptions{
+			Host:      "https://kubernetes.example.com",
+			TokenFile: writeToken(t, `{"aud":"argocd-cmp-replicator"}`),
+			Audience:  "argocd-cmp-replicator",
+		})
+		require.NoError(t, err)
+	})
+	t.Run("wrong-audience", func(t *testing.T) {
+		_, err := tokenFileConfig(ClientOptions{
+			Host:      "https://kubernetes.example.com",
+			TokenFile: writeToken(t, `{"aud":["https://kubernetes.default.svc"]}`),
+			Audience:  "argocd-cmp-replicator",
+		})
+		require.Error(t, err)
+	})
+	t.Run("missing-file", func(t *testing.T) {
+		_, err := tokenFileConfig(ClientOptions{
+			Host:      "https://kubernetes.example.com",
+			TokenFile: filepath.Join(t.TempDir(), "token"),
+		})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,99 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	argocdcommon "github.com/argoproj/argo-cd/v2/common"
+	argocdv1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NewForArgoCDCluster returns a client for a cluster registered in ArgoCD, found by its name or server URL.
+// Policy is not copied as the new client is meant to be used as a SecretSource.
+func (c *Client) NewForArgoCDCluster(ctx context.Context, argocdNamespace, nameOrServer string) (*Client, error) {
+	cluster, err := c.getArgoCDCluster(ctx, argocdNamespace, nameOrServer)
+	if err != nil {
+		return nil, err
+	}
+
+	if cluster.Server == argocdv1alpha1.KubernetesInternalAPIServerAddr {
+		slog.Debug("ArgoCD cluster is the local cluster", "cluster", cluster.Name)
+		return &Client{
+			Interface:   c.Interface,
+			ClusterName: cluster.Name,
+			Options:     c.Options,
+		}, nil
+	}
+
+	// Local cluster is handled above, so this never falls back to in-cluster config and can't panic
+	config := cluster.RawRestConfig()
+	c.Options.applyRateLimits(config)
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		Interface:   clientset,
+		ClusterName: cluster.Name,
+		Options:     c.Options,
+	}, nil
+}
+
+func (c *Client) getArgoCDCluster(ctx context.Context, argocdNamespace, nameOrServer string) (*argocdv1alpha1.Cluster, error) {
+	var secrets *corev1.SecretList
+	err := c.Options.Retry.do(ctx, "list ArgoCD clusters", func() (err error) {
+		secrets, err = c.CoreV1().Secrets(argocdNamespace).List(ctx, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("%s=%s", argocdcommon.LabelKeySecretType, argocdcommon.LabelValueSecretTypeCluster),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, secret := range secrets.Items {
+		cluster, err := clusterFromSecret(secret)
+		if err != nil {
+			return nil, err
+		}
+		if cluster.Name == nameOrServer || cluster.Server == nameOrServer {
+			slog.Debug(
+				"Found ArgoCD cluster",
+				"name", cluster.Name,
+				"server", cluster.Server,
+				"secret", secret.Name,
+			)
+			return cluster, nil
+		}
+	}
+
+	// ArgoCD does not require a secret for the local cluster
+	if nameOrServer == "in-cluster" || nameOrServer == argocdv1alpha1.KubernetesInternalAPIServerAddr {
+		return &argocdv1alpha1.Cluster{
+			Name:   "in-cluster",
+			Server: argocdv1alpha1.KubernetesInternalAPIServerAddr,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("ArgoCD cluster %q not found in namespace %s", nameOrServer, argocdNamespace)
+}
+
+// clusterFromSecret reads cluster secret the same way ArgoCD does, ignoring fields that are not used to connect
+func clusterFromSecret(secret corev1.Secret) (*argocdv1alpha1.Cluster, error) {
+	cluster := &argocdv1alpha1.Cluster{
+		Name:   string(secret.Data["name"]),
+		Server: string(secret.Data["server"]),
+	}
+	if len(secret.Data["config"]) > 0 {
+		if err := json.Unmarshal(secret.Data["config"], &cluster.Config); err != nil {
+			return nil, fmt.Errorf("failed to parse config of ArgoCD cluster secret %s/%s: %w", secret.Namespace, secret.Name, err)
+		}
+	}
+	return cluster, nil
+}
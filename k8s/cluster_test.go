@@ -0,0 +1,134 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func newClusterSecret(name, server, config string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cluster-" + name,
+			Namespace: "argocd",
+			Labels: map[string]string{
+				"argocd.argoproj.io/secret-type": "cluster",
+			},
+		},
+		Data: map[string][]byte{
+			"name":   []byte(name),
+			"server": []byte(server),
+			"config": []byte(config),
+		},
+	}
+}
+
+func TestGetArgoCDCluster(t *testing.T) {
+	client := Client{
+		Interface: testClient.NewSimpleClientset(
+			newClusterSecret("vault", "https://vault.example.com:6443", `{
+				"bearerToken": "some-token",
+				"tlsClientConfig": {"insecure": false, "caData": "Y2E="}
+			}`),
+			newClusterSecret("eks", "https://eks.example.com", `{
+				"execProviderConfig": {
+					"command": "argocd-k8s-auth",
+					"args": ["aws", "--cluster-name", "eks"],
+					"apiVersion": "client.authentication.k8s.io/v1beta1"
+				},
+				"tlsClientConfig": {"caData": "Y2E="}
+			}`),
+		),
+	}
+
+	t.Run("by-name", func(t *testing.T) {
+		cluster, err := client.getArgoCDCluster(context.TODO(), "argocd", "vault")
+		require.NoError(t, err)
+
+		config := cluster.RawRestConfig()
+		require.Equal(t, "https://vault.example.com:6443", config.Host)
+		require.Equal(t, "some-token", config.BearerToken)
+		require.Equal(t, []byte("ca"), config.TLSClientConfig.CAData)
+	})
+	t.Run("by-server", func(t *testing.T) {
+		cluster, err := client.getArgoCDCluster(context.TODO(), "argocd", "https://eks.example.com")
+		require.NoError(t, err)
+		require.Equal(t, "eks", cluster.Name)
+
+		config := cluster.RawRestConfig()
+		require.NotNil(t, config.ExecProvider)
+		require.Equal(t, "argocd-k8s-auth", config.ExecProvider.Command)
+		require.Equal(t, []string{"aws", "--cluster-name", "eks"}, config.ExecProvider.Args)
+	})
+	t.Run("not-found", func(t *testing.T) {
+		_, err := client.getArgoCDCluster(context.TODO(), "argocd", "foo")
+		require.Error(t, err)
+	})
+	t.Run("broken-config", func(t *testing.T) {
+		_, err := clusterFromSecret(*newClusterSecret("broken", "https://broken.example.com", `not json`))
+		require.Error(t, err)
+	})
+	t.Run("in-cluster-without-secret", func(t *testing.T) {
+		local, err := client.NewForArgoCDCluster(context.TODO(), "argocd", "in-cluster")
+		require.NoError(t, err)
+		require.Equal(t, client.Interface, local.Interface)
+		require.Equal(t, "cluster in-cluster", local.Describe())
+	})
+}
+
+func TestListCandidatesFromArgoCDCluster(t *testing.T) {
+	client := Client{
+		Interface: testClient.NewSimpleClientset(
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "labeled-secret",
+					Namespace: "my-test-namespace",
+					Labels: map[string]string{
+						types.ReplicatorLabel: "true",
+					},
+					Annotations: map[string]string{
+						types.ReplicatorAnnotationFromCluster: "forged",
+					},
+				},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "no-annotations",
+					Namespace: "my-test-namespace",
+					Labels: map[string]string{
+						types.ReplicatorLabel: "true",
+					},
+				},
+			},
+		),
+	}
+
+	t.Run("local", func(t *testing.T) {
+		secrets, err := client.ListCandidates(context.TODO(), "")
+		require.NoError(t, err)
+		require.Len(t, secrets, 2)
+		for _, secret := range secrets {
+			require.NotContains(t, secret.Annotations, types.ReplicatorAnnotationFromCluster)
+		}
+	})
+	t.Run("registered", func(t *testing.T) {
+		cluster := Client{
+			Interface:   client.Interface,
+			ClusterName: "vault",
+		}
+
+		secrets, err := cluster.ListCandidates(context.TODO(), "")
+		require.NoError(t, err)
+		require.Len(t, secrets, 2)
+		for _, secret := range secrets {
+			require.Equal(t, "vault", secret.Annotations[types.ReplicatorAnnotationFromCluster], secret.Name)
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	applycorev1 "k8s.io/client-go/applyconfigurations/core/v1"
+	applymetav1 "k8s.io/client-go/applyconfigurations/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// ConsumersFieldManager owns consumer status written back to source secrets
+const ConsumersFieldManager = "argocd-cmp-replicator-status"
+
+const (
+	// ConsumerStatusAnnotation keeps consumers in an annotation of the source secret
+	ConsumerStatusAnnotation = "annotation"
+	// ConsumerStatusConfigMap keeps consumers in a ConfigMap next to the source secret, owned by it
+	ConsumerStatusConfigMap = "configmap"
+)
+
+// ConsumersConfigMapKey is where ConsumerStatusConfigMap keeps consumers
+const ConsumersConfigMapKey = "consumers.json"
+
+// Consumer is an Application that replicates the secret to a destination
+type Consumer struct {
+	App         string    `json:"app"`
+	Project     string    `json:"project,omitempty"`
+	Destination string    `json:"destination"`
+	LastRender  time.Time `json:"lastRender"`
+}
+
+// ConsumerStatus writes back who consumes source secrets.
+// Renders of different Applications update the same list, so it is applied with
+// the resource version it was read at, and read again on conflict.
+type ConsumerStatus struct {
+	// Mode is one of ConsumerStatus*
+	Mode string
+	// Consumer is this render, LastRender is set on Publish
+	Consumer Consumer
+	// TTL prunes consumers that were not seen for longer
+	TTL time.Duration
+	// Interval is how often the same consumer is written back, to not update secrets on every refresh
+	Interval time.Duration
+
+	client kubernetes.Interface
+	now    func() time.Time
+
+	mu      sync.Mutex
+	pending []corev1.Secret
+}
+
+func NewConsumerStatus(client kubernetes.Interface, mode string) (*ConsumerStatus, error) {
+	if mode != ConsumerStatusAnnotation && mode != ConsumerStatusConfigMap {
+		return nil, fmt.Errorf("unknown consumer status mode: %s", mode)
+	}
+	return &ConsumerStatus{
+		Mode:   mode,
+		client: client,
+		now:    time.Now,
+	}, nil
+}
+
+// Observe keeps replicated secrets for Publish, it is meant for Client.OnDecision.
+// Only secrets read from the API of this cluster can be written back.
+func (s *ConsumerStatus) Observe(secret corev1.Secret, decision Decision) {
+	if decision.Decision != DecisionReplicated || secret.UID == "" || secret.Annotations[types.ReplicatorAnnotationFromCluster] != "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Only what is needed to find the secret, not its data
+	s.pending = append(s.pending, corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: secret.Namespace,
+			Name:      secret.Name,
+			UID:       secret.UID,
+		},
+	})
+}
+
+// Publish writes this consumer back to observed secrets. Call it only if the render succeeded.
+func (s *ConsumerStatus) Publish(ctx context.Context) error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	errs := []error{}
+	for _, secret := range pending {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			return s.update(ctx, secret)
+		})
+		if err != nil {
+			slog.Warn("Failed to write back consumer status", "name", secret.Name, "namespace", secret.Namespace, "err", err)
+			errs = append(errs, fmt.Errorf("%s/%s: %w", secret.Namespace, secret.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *ConsumerStatus) update(ctx context.Context, secret corev1.Secret) error {
+	consumers, resourceVersion, err := s.read(ctx, secret)
+	if err != nil {
+		return err
+	}
+
+	now := s.now().UTC().Truncate(time.Second)
+	updated, changed := s.merge(consumers, now)
+	if !changed {
+		slog.Debug("Consumer status is up to date", "name", secret.Name, "namespace", secret.Namespace)
+		return nil
+	}
+
+	value, err := json.Marshal(updated)
+	if err != nil {
+		return err
+	}
+
+	switch s.Mode {
+	case ConsumerStatusAnnotation:
+		apply := applycorev1.Secret(secret.Name, secret.Namespace).
+			WithResourceVersion(resourceVersion).
+			WithAnnotations(map[string]string{types.ReplicatorAnnotationConsumers: string(value)})
+		_, err = s.client.CoreV1().Secrets(secret.Namespace).Apply(ctx, apply, metav1.ApplyOptions{FieldManager: ConsumersFieldManager, Force: true})
+	case ConsumerStatusConfigMap:
+		apply := applycorev1.ConfigMap(ConsumersConfigMapName(secret.Name), secret.Namespace).
+			WithLabels(map[string]string{types.ReplicatorLabelConsumersOf: consumersOfLabelValue(secret.Name)}).
+			WithOwnerReferences(applymetav1.OwnerReference().
+				WithAPIVersion("v1").
+				WithKind("Secret").
+				WithName(secret.Name).
+				WithUID(secret.UID)).
+			WithData(map[string]string{ConsumersConfigMapKey: string(value)})
+		if resourceVersion != "" {
+			apply = apply.WithResourceVersion(resourceVersion)
+		}
+		_, err = s.client.CoreV1().ConfigMaps(secret.Namespace).Apply(ctx, apply, metav1.ApplyOptions{FieldManager: ConsumersFieldManager, Force: true})
+	}
+	if err != nil {
+		return err
+	}
+	slog.Debug("Wrote back consumer status", "name", secret.Name, "namespace", secret.Namespace, "consumers", len(updated))
+	return nil
+}
+
+// read returns current consumers and the resource version of the object they were read from
+func (s *ConsumerStatus) read(ctx context.Context, secret corev1.Secret) ([]Consumer, string, error) {
+	value, resourceVersion := "", ""
+	switch s.Mode {
+	case ConsumerStatusAnnotation:
+		current, err := s.client.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, "", err
+		}
+		value, resourceVersion = current.Annotations[types.ReplicatorAnnotationConsumers], current.ResourceVersion
+	case ConsumerStatusConfigMap:
+		current, err := s.client.CoreV1().ConfigMaps(secret.Namespace).Get(ctx, ConsumersConfigMapName(secret.Name), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, "", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		value, resourceVersion = current.Data[ConsumersConfigMapKey], current.ResourceVersion
+	}
+
+	consumers := []Consumer{}
+	if value == "" {
+		return consumers, resourceVersion, nil
+	}
+	if err := json.Unmarshal([]byte(value), &consumers); err != nil {
+		// Start over rather than never recording consumers again
+		slog.Warn("Ignoring unreadable consumer status", "name", secret.Name, "namespace", secret.Namespace, "err", err)
+		return []Consumer{}, resourceVersion, nil
+	}
+	return consumers, resourceVersion, nil
+}
+
+// merge records this consumer, prunes the ones not seen within TTL and tells if anything changed worth writing
+func (s *ConsumerStatus) merge(consumers []Consumer, now time.Time) ([]Consumer, bool) {
+	fresh, pruned := false, false
+	updated := []Consumer{}
+	for _, consumer := range consumers {
+		if consumer.App == s.Consumer.App && consumer.Destination == s.Consumer.Destination {
+			fresh = consumer.Project == s.Consumer.Project && now.Sub(consumer.LastRender) < s.Interval
+			continue
+		}
+		if s.TTL > 0 && now.Sub(consumer.LastRender) > s.TTL {
+			pruned = true
+			continue
+		}
+		updated = append(updated, consumer)
+	}
+
+	self := s.Consumer
+	self.LastRender = now
+	updated = append(updated, self)
+	slices.SortFunc(updated, func(a, b Consumer) int {
+		if c := strings.Compare(a.App, b.App); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Destination, b.Destination)
+	})
+	return updated, pruned || !fresh
+}
+
+// consumersOfLabelValue keeps the secret name within the limit of label values, hashing it so it stays unique
+func consumersOfLabelValue(secretName string) string {
+	if len(secretName) <= 63 {
+		return secretName
+	}
+	sum := sha256.Sum256([]byte(secretName))
+	return secretName[:63-17] + "-" + hex.EncodeToString(sum[:8])
+}
+
+// ConsumersConfigMapName is the name of the ConfigMap with consumers of the secret
+func ConsumersConfigMapName(secretName string) string {
+	name := secretName + "-consumers"
+	// Keep it within the limit of object names, hashing the secret name so it stays unique
+	if len(name) > 253 {
+		sum := sha256.Sum256([]byte(secretName))
+		name = secretName[:253-len("-consumers")-17] + "-" + hex.EncodeToString(sum[:8]) + "-consumers"
+	}
+	return name
+}
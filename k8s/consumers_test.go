@@ -0,0 +1,168 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestConsumerStatus(t *testing.T) {
+	newClientset := func() *testClient.Clientset {
+		clientset := testClient.NewSimpleClientset(
+			newSecretWithUID("local", "my-test-namespace", "uid-local", nil),
+			newSecretWithUID("not-allowed", "some-other-namespace", "uid-not-allowed", nil),
+		)
+		// The fake clientset can only apply to existing objects
+		clientset.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			patch := action.(k8stesting.PatchAction)
+			if patch.GetPatchType() != k8stypes.ApplyPatchType {
+				return false, nil, nil
+			}
+			_, err := clientset.Tracker().Get(corev1.SchemeGroupVersion.WithResource("configmaps"), patch.GetNamespace(), patch.GetName())
+			if !apierrors.IsNotFound(err) {
+				return false, nil, err
+			}
+			configMap := &corev1.ConfigMap{}
+			if err := json.Unmarshal(patch.GetPatch(), configMap); err != nil {
+				return true, nil, err
+			}
+			return true, configMap, clientset.Tracker().Create(corev1.SchemeGroupVersion.WithResource("configmaps"), configMap, patch.GetNamespace())
+		})
+		return clientset
+	}
+
+	render := func(clientset *testClient.Clientset, mode, app string, now time.Time) {
+		status, err := NewConsumerStatus(clientset, mode)
+		require.NoError(t, err)
+		status.Consumer = Consumer{App: app, Project: "default", Destination: "in-cluster/my-test-namespace"}
+		status.TTL = 24 * time.Hour
+		status.Interval = time.Hour
+		status.now = func() time.Time { return now }
+		client := Client{
+			Interface:  clientset,
+			OnDecision: status.Observe,
+		}
+		_, err = client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		require.NoError(t, status.Publish(context.TODO()))
+	}
+	consumers := func(value string) []Consumer {
+		result := []Consumer{}
+		require.NoError(t, json.Unmarshal([]byte(value), &result))
+		return result
+	}
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("annotation", func(t *testing.T) {
+		clientset := newClientset()
+		get := func(name, namespace string) *corev1.Secret {
+			secret, err := clientset.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+			require.NoError(t, err)
+			return secret
+		}
+
+		render(clientset, ConsumerStatusAnnotation, "my-app", now)
+		render(clientset, ConsumerStatusAnnotation, "another-app", now.Add(time.Minute))
+		require.Equal(t, []Consumer{
+			{App: "another-app", Project: "default", Destination: "in-cluster/my-test-namespace", LastRender: now.Add(time.Minute)},
+			{App: "my-app", Project: "default", Destination: "in-cluster/my-test-namespace", LastRender: now},
+		}, consumers(get("local", "my-test-namespace").Annotations[types.ReplicatorAnnotationConsumers]))
+		require.Empty(t, get("not-allowed", "some-other-namespace").Annotations)
+
+		// Within the interval the same consumer is not written again
+		render(clientset, ConsumerStatusAnnotation, "my-app", now.Add(30*time.Minute))
+		require.Equal(t, now, consumers(get("local", "my-test-namespace").Annotations[types.ReplicatorAnnotationConsumers])[1].LastRender)
+
+		// Past the TTL the other consumer is pruned
+		render(clientset, ConsumerStatusAnnotation, "my-app", now.Add(25*time.Hour))
+		require.Equal(t, []Consumer{
+			{App: "my-app", Project: "default", Destination: "in-cluster/my-test-namespace", LastRender: now.Add(25 * time.Hour)},
+		}, consumers(get("local", "my-test-namespace").Annotations[types.ReplicatorAnnotationConsumers]))
+	})
+	t.Run("configmap", func(t *testing.T) {
+		clientset := newClientset()
+
+		render(clientset, ConsumerStatusConfigMap, "my-app", now)
+		render(clientset, ConsumerStatusConfigMap, "another-app", now.Add(time.Minute))
+		configMap, err := clientset.CoreV1().ConfigMaps("my-test-namespace").Get(context.TODO(), "local-consumers", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "local", configMap.Labels[types.ReplicatorLabelConsumersOf])
+		require.Len(t, configMap.OwnerReferences, 1)
+		require.Equal(t, k8stypes.UID("uid-local"), configMap.OwnerReferences[0].UID)
+		require.Len(t, consumers(configMap.Data[ConsumersConfigMapKey]), 2)
+
+		secret, err := clientset.CoreV1().Secrets("my-test-namespace").Get(context.TODO(), "local", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Empty(t, secret.Annotations)
+
+		configMaps, err := clientset.CoreV1().ConfigMaps("").List(context.TODO(), metav1.ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, configMaps.Items, 1)
+	})
+	t.Run("unknown-mode", func(t *testing.T) {
+		_, err := NewConsumerStatus(newClientset(), "status")
+		require.Error(t, err)
+	})
+}
+
+func TestConsumerStatusMerge(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	status := &ConsumerStatus{
+		Consumer: Consumer{App: "my-app", Project: "default", Destination: "in-cluster/my-test-namespace"},
+		TTL:      24 * time.Hour,
+		Interval: time.Hour,
+	}
+
+	// An expired consumer that sorts before this one is pruned even if this one is fresh
+	updated, changed := status.merge([]Consumer{
+		{App: "another-app", Project: "default", Destination: "in-cluster/my-test-namespace", LastRender: now.Add(-48 * time.Hour)},
+		{App: "my-app", Project: "default", Destination: "in-cluster/my-test-namespace", LastRender: now.Add(-time.Minute)},
+	}, now)
+	require.True(t, changed)
+	require.Equal(t, []Consumer{
+		{App: "my-app", Project: "default", Destination: "in-cluster/my-test-namespace", LastRender: now},
+	}, updated)
+
+	_, changed = status.merge([]Consumer{
+		{App: "another-app", Project: "default", Destination: "in-cluster/my-test-namespace", LastRender: now.Add(-time.Hour)},
+		{App: "my-app", Project: "default", Destination: "in-cluster/my-test-namespace", LastRender: now.Add(-time.Minute)},
+	}, now)
+	require.False(t, changed)
+
+	_, changed = status.merge([]Consumer{
+		{App: "my-app", Project: "other", Destination: "in-cluster/my-test-namespace", LastRender: now.Add(-time.Minute)},
+	}, now)
+	require.True(t, changed)
+
+	_, changed = status.merge([]Consumer{}, now)
+	require.True(t, changed)
+}
+
+func TestConsumersOfLabelValue(t *testing.T) {
+	require.Equal(t, "foo", consumersOfLabelValue("foo"))
+	long := consumersOfLabelValue(strings.Repeat("a", 64))
+	require.Len(t, long, 63)
+	require.NotEqual(t, long, consumersOfLabelValue(strings.Repeat("a", 65)))
+}
+
+func TestConsumersConfigMapName(t *testing.T) {
+	require.Equal(t, "foo-consumers", ConsumersConfigMapName("foo"))
+	long := ConsumersConfigMapName(strings.Repeat("a", 250))
+	require.Len(t, long, 253)
+	require.NotEqual(t, long, ConsumersConfigMapName(strings.Repeat("a", 251)))
+}
@@ -0,0 +1,94 @@
+package k8s
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	DecisionReplicated = "replicated"
+	DecisionSkipped    = "skipped"
+	// DecisionDenied failed the render, see InvalidSecretsFail
+	DecisionDenied = "denied"
+	// DecisionKept is a replica kept from the last good render, see KeepReplica
+	DecisionKept = "kept"
+)
+
+const (
+	MatcherNamespace = "namespace"
+	MatcherWildcard  = "wildcard"
+	MatcherList      = "list"
+)
+
+// Reasons of skipped secrets in more detail than SkipReason*
+const (
+	ReasonNotAllowed    = "not-allowed"
+	ReasonGrantUnsigned = "grant-signature"
+	ReasonFieldManagers = "field-managers"
+	ReasonGrantWindow   = "grant-window"
+	ReasonStale         = "stale"
+	ReasonInvalid       = "invalid"
+	ReasonRetired       = "retired"
+	ReasonArgoCDOptions = "argocd-options"
+	ReasonCertExpired   = "cert-expired"
+)
+
+// Decision is what happened to a candidate secret during the render.
+// It never holds secret data, only a digest of it.
+type Decision struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Cluster   string `json:"cluster,omitempty"`
+	Decision  string `json:"decision"`
+	Matcher   string `json:"matcher,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Digest    string `json:"digest"`
+}
+
+// Digest identifies the content of the secret without revealing it
+func Digest(secret corev1.Secret) string {
+	keys := make([]string, 0, len(secret.Data)+len(secret.StringData))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	for key := range secret.StringData {
+		if _, ok := secret.Data[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	hash := sha256.New()
+	hash.Write([]byte(secret.Type))
+	for _, key := range keys {
+		value, ok := secret.Data[key]
+		if !ok {
+			value = []byte(secret.StringData[key])
+		}
+		hash.Write([]byte{0})
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write(value)
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+func (c *Client) decided(secret corev1.Secret, decision Decision) {
+	if c.OnDecision == nil {
+		return
+	}
+	c.OnDecision(secret, describe(secret, decision))
+}
+
+// describe fills in which secret the decision is about
+func describe(secret corev1.Secret, decision Decision) Decision {
+	decision.Namespace = secret.Namespace
+	decision.Name = secret.Name
+	decision.Cluster = secret.Annotations[types.ReplicatorAnnotationFromCluster]
+	decision.Digest = Digest(secret)
+	return decision
+}
@@ -0,0 +1,102 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDecisions(t *testing.T) {
+	valid := map[string][]byte{
+		corev1.BasicAuthPasswordKey: []byte("super-secret-value"),
+	}
+
+	newClient := func(invalidSecrets string) (*Client, map[string]Decision) {
+		decisions := map[string]Decision{}
+		return &Client{
+			Interface: testClient.NewSimpleClientset(
+				newBasicAuthSecret("local", "my-test-namespace", nil, valid),
+				newBasicAuthSecret("invalid", "my-test-namespace", nil, nil),
+				newBasicAuthSecret("wildcard", "some-other-namespace", map[string]string{
+					types.ReplicatorAnnotationAllowedNamespaces: "*",
+				}, valid),
+				newBasicAuthSecret("listed", "some-other-namespace", map[string]string{
+					types.ReplicatorAnnotationAllowedNamespaces: "foo,my-test-namespace",
+				}, valid),
+				newBasicAuthSecret("not-allowed", "some-other-namespace", nil, valid),
+				newBasicAuthSecret("expired-grant", "some-other-namespace", map[string]string{
+					types.ReplicatorAnnotationAllowedNamespaces: "*",
+					types.ReplicatorAnnotationNotAfter:          time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+				}, valid),
+			),
+			Policy: Policy{
+				InvalidSecrets: invalidSecrets,
+			},
+			OnDecision: func(_ corev1.Secret, decision Decision) {
+				decisions[decision.Name] = decision
+			},
+		}, decisions
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		client, decisions := newClient(InvalidSecretsSkip)
+		_, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		require.Len(t, decisions, 6)
+
+		for name, expected := range map[string]Decision{
+			"local":         {Decision: DecisionReplicated, Matcher: MatcherNamespace},
+			"invalid":       {Decision: DecisionSkipped, Matcher: MatcherNamespace, Reason: ReasonInvalid},
+			"wildcard":      {Decision: DecisionReplicated, Matcher: MatcherWildcard},
+			"listed":        {Decision: DecisionReplicated, Matcher: MatcherList},
+			"not-allowed":   {Decision: DecisionSkipped, Reason: ReasonNotAllowed},
+			"expired-grant": {Decision: DecisionSkipped, Matcher: MatcherWildcard, Reason: ReasonGrantWindow},
+		} {
+			decision := decisions[name]
+			require.Equal(t, expected.Decision, decision.Decision, name)
+			require.Equal(t, expected.Matcher, decision.Matcher, name)
+			require.Equal(t, expected.Reason, decision.Reason, name)
+			require.Regexp(t, "^sha256:[0-9a-f]{64}$", decision.Digest, name)
+		}
+		require.Equal(t, decisions["local"].Digest, decisions["wildcard"].Digest, "same content, same digest")
+		require.NotEqual(t, decisions["local"].Digest, decisions["invalid"].Digest)
+	})
+	t.Run("fail", func(t *testing.T) {
+		client, decisions := newClient(InvalidSecretsFail)
+		_, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.ErrorIs(t, err, ErrPolicyDenied)
+		require.Equal(t, DecisionDenied, decisions["invalid"].Decision)
+	})
+}
+
+func TestDigest(t *testing.T) {
+	secret := corev1.Secret{
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"a": []byte("1"),
+			"b": []byte("2"),
+		},
+	}
+	digest := Digest(secret)
+	require.Equal(t, digest, Digest(*secret.DeepCopy()))
+
+	// Keys and values must not run into each other
+	moved := corev1.Secret{
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"a": []byte("1b"),
+			"":  []byte("2"),
+		},
+	}
+	require.NotEqual(t, digest, Digest(moved))
+
+	secret.Type = corev1.SecretTypeBasicAuth
+	require.NotEqual(t, digest, Digest(secret))
+}
@@ -0,0 +1,84 @@
+package k8s
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// Errors returned by the client wrap one of these, so callers can tell why the render failed with errors.Is
+var (
+	// ErrUnreachable means the cluster could not be reached or did not answer in time
+	ErrUnreachable = errors.New("cannot reach cluster")
+	// ErrForbidden means the plugin is not allowed to read secrets
+	ErrForbidden = errors.New("forbidden")
+	// ErrPolicyDenied means a secret was refused by the policy and the policy says to fail the render
+	ErrPolicyDenied = errors.New("denied by policy")
+	// ErrRemovalDenied means the render would remove replicas the Application manages, see Removals
+	ErrRemovalDenied = errors.New("removal denied")
+)
+
+// Reasons ErrorReason returns, only ErrorReasonPolicyDenied and ErrorReasonRemovalDenied are not API errors
+const (
+	ErrorReasonUnreachable   = "unreachable"
+	ErrorReasonForbidden     = "forbidden"
+	ErrorReasonPolicyDenied  = "policy-denied"
+	ErrorReasonRemovalDenied = "removal-denied"
+	ErrorReasonOther         = "other"
+)
+
+// ErrorReason returns short reason of the typed error for logs and metrics
+func ErrorReason(err error) string {
+	switch {
+	case errors.Is(err, ErrUnreachable):
+		return ErrorReasonUnreachable
+	case errors.Is(err, ErrForbidden):
+		return ErrorReasonForbidden
+	case errors.Is(err, ErrPolicyDenied):
+		return ErrorReasonPolicyDenied
+	case errors.Is(err, ErrRemovalDenied):
+		return ErrorReasonRemovalDenied
+	default:
+		return ErrorReasonOther
+	}
+}
+
+// classify wraps the API error with one of the typed errors, if it is any of them
+func classify(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err):
+		return fmt.Errorf("%w: %w", ErrForbidden, err)
+	case isUnreachable(err):
+		return fmt.Errorf("%w: %w", ErrUnreachable, err)
+	default:
+		return err
+	}
+}
+
+// retryable errors are the ones that may go away if the same call is made again
+func retryable(err error) bool {
+	return apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsInternalError(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsUnexpectedServerError(err) ||
+		isUnreachable(err)
+}
+
+func isUnreachable(err error) bool {
+	if utilnet.IsConnectionRefused(err) || utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err) {
+		return true
+	}
+	if status, ok := err.(apierrors.APIStatus); ok || errors.As(err, &status) {
+		code := status.Status().Code
+		return code == 502 || code == 503 || code == 504
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,191 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// EventComponent is the source of Events recorded on source secrets
+const EventComponent = "argocd-cmp-replicator"
+
+const (
+	EventReasonReplicated = "Replicated"
+	EventReasonDenied     = "Denied"
+)
+
+// EventRecorder records Events on source secrets about what renders did with them.
+// Events are kept until Publish, so a failed render does not claim it replicated anything.
+type EventRecorder struct {
+	// App and Destination are what the events are about, Destination is <cluster>/<namespace>
+	App         string
+	Destination string
+
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+	sink        *throttledSink
+	emitted     atomic.Int64
+
+	mu      sync.Mutex
+	pending []pendingEvent
+}
+
+type pendingEvent struct {
+	secret   corev1.ObjectReference
+	decision Decision
+}
+
+// NewEventRecorder records events with the client. Every render is a new process,
+// so in addition to the usual in-process aggregation, repeats of the same event
+// only bump the count of the existing Event once per interval.
+func NewEventRecorder(client kubernetes.Interface, interval time.Duration) *EventRecorder {
+	sink := &throttledSink{
+		client:   client.CoreV1(),
+		interval: interval,
+		now:      time.Now,
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(sink)
+	return &EventRecorder{
+		broadcaster: broadcaster,
+		recorder:    broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: EventComponent}),
+		sink:        sink,
+	}
+}
+
+// Observe keeps the decision for Publish, it is meant for Client.OnDecision.
+// Only secrets read from the API of this cluster get events - other sources have nothing to record them on.
+func (r *EventRecorder) Observe(secret corev1.Secret, decision Decision) {
+	if secret.UID == "" || secret.Annotations[types.ReplicatorAnnotationFromCluster] != "" {
+		return
+	}
+	// Every secret not allowed to the destination would get an event on every render
+	if decision.Decision == DecisionSkipped && decision.Reason == ReasonNotAllowed {
+		return
+	}
+	// Owners retire secrets on purpose, that's not worth a warning
+	if decision.Decision == DecisionSkipped && decision.Reason == ReasonRetired {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, pendingEvent{
+		secret: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Secret",
+			Namespace:  secret.Namespace,
+			Name:       secret.Name,
+			UID:        secret.UID,
+		},
+		decision: decision,
+	})
+}
+
+// Publish records events for observed decisions and waits for them to be written until the context is done.
+// Replications are only recorded if the render succeeded.
+func (r *EventRecorder) Publish(ctx context.Context, succeeded bool) {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+
+	for _, event := range pending {
+		switch event.decision.Decision {
+		case DecisionReplicated:
+			if !succeeded {
+				continue
+			}
+			r.recorder.Eventf(&event.secret, corev1.EventTypeNormal, EventReasonReplicated, "Replicated to %s by app %s", r.Destination, r.App)
+		default:
+			r.recorder.Eventf(&event.secret, corev1.EventTypeWarning, EventReasonDenied, "Denied for app %s: %s", r.App, event.decision.Reason)
+		}
+		r.emitted.Add(1)
+	}
+
+	// Events are written asynchronously, wait for them before the process exits
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for r.sink.written.Load() < r.emitted.Load() {
+		select {
+		case <-ctx.Done():
+			slog.Warn("Gave up waiting for events to be recorded", "emitted", r.emitted.Load(), "written", r.sink.written.Load())
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown stops the recorder, call Publish first
+func (r *EventRecorder) Shutdown() {
+	r.broadcaster.Shutdown()
+}
+
+// throttledSink names events after what they say, so the same event from another render
+// is found and updated instead of creating a new one, at most once per interval
+type throttledSink struct {
+	client   typedcorev1.CoreV1Interface
+	interval time.Duration
+	now      func() time.Time
+	written  atomic.Int64
+}
+
+func (s *throttledSink) Create(event *corev1.Event) (*corev1.Event, error) {
+	defer s.written.Add(1)
+
+	event.Name = eventName(event)
+	events := s.client.Events(event.Namespace)
+	created, err := events.Create(context.TODO(), event, metav1.CreateOptions{})
+	if !apierrors.IsAlreadyExists(err) {
+		return created, err
+	}
+
+	existing, err := events.Get(context.TODO(), event.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if s.now().Sub(existing.LastTimestamp.Time) < s.interval {
+		slog.Debug("Event was recorded recently, skipped", "name", event.Name, "namespace", event.Namespace, "reason", event.Reason)
+		return existing, nil
+	}
+	existing.Count++
+	existing.LastTimestamp = event.LastTimestamp
+	return events.Update(context.TODO(), existing, metav1.UpdateOptions{})
+}
+
+func (s *throttledSink) Update(event *corev1.Event) (*corev1.Event, error) {
+	defer s.written.Add(1)
+	return s.client.Events(event.Namespace).Update(context.TODO(), event, metav1.UpdateOptions{})
+}
+
+func (s *throttledSink) Patch(event *corev1.Event, data []byte) (*corev1.Event, error) {
+	defer s.written.Add(1)
+	return s.client.Events(event.Namespace).Patch(context.TODO(), event.Name, k8stypes.StrategicMergePatchType, data, metav1.PatchOptions{})
+}
+
+// eventName is the name of the involved object and a hash of what the event says
+func eventName(event *corev1.Event) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%s", event.InvolvedObject.UID, event.Type, event.Reason, event.Message)))
+	suffix := "." + hex.EncodeToString(hash[:8])
+	// Keep it within the limit of object names, the hash keeps it unique
+	name := event.InvolvedObject.Name
+	if len(name)+len(suffix) > 253 {
+		name = name[:253-len(suffix)]
+	}
+	return name + suffix
+}
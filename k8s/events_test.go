@@ -0,0 +1,100 @@
+package k8s
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEvents(t *testing.T) {
+	clientset := testClient.NewSimpleClientset(
+		newSecretWithUID("local", "my-test-namespace", "uid-local", nil),
+		newSecretWithUID("no-uid", "my-test-namespace", "", nil),
+		newSecretWithUID("not-allowed", "some-other-namespace", "uid-not-allowed", nil),
+		newSecretWithUID("expired-grant", "some-other-namespace", "uid-expired-grant", map[string]string{
+			types.ReplicatorAnnotationAllowedNamespaces: "*",
+			types.ReplicatorAnnotationNotAfter:          time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+		}),
+	)
+
+	render := func(interval time.Duration, succeeded bool) {
+		events := NewEventRecorder(clientset, interval)
+		events.App = "my-app"
+		events.Destination = "in-cluster/my-test-namespace"
+		client := Client{
+			Interface:  clientset,
+			OnDecision: events.Observe,
+		}
+		_, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+		defer cancel()
+		events.Publish(ctx, succeeded)
+		events.Shutdown()
+	}
+	list := func() map[string]corev1.Event {
+		events, err := clientset.CoreV1().Events("").List(context.TODO(), metav1.ListOptions{})
+		require.NoError(t, err)
+		result := map[string]corev1.Event{}
+		for _, event := range events.Items {
+			result[event.InvolvedObject.Name] = event
+		}
+		return result
+	}
+
+	t.Run("failed-render", func(t *testing.T) {
+		render(time.Hour, false)
+		events := list()
+		require.Len(t, events, 1)
+		require.Equal(t, EventReasonDenied, events["expired-grant"].Reason)
+		require.Equal(t, "Denied for app my-app: grant-window", events["expired-grant"].Message)
+		require.Equal(t, corev1.EventTypeWarning, events["expired-grant"].Type)
+		require.Equal(t, "some-other-namespace", events["expired-grant"].Namespace)
+		require.Equal(t, EventComponent, events["expired-grant"].Source.Component)
+	})
+	t.Run("render", func(t *testing.T) {
+		render(time.Hour, true)
+		events := list()
+		require.Len(t, events, 2)
+		require.Equal(t, EventReasonReplicated, events["local"].Reason)
+		require.Equal(t, "Replicated to in-cluster/my-test-namespace by app my-app", events["local"].Message)
+		require.Equal(t, k8stypes.UID("uid-local"), events["local"].InvolvedObject.UID)
+		require.Equal(t, int32(1), events["expired-grant"].Count, "repeat within the interval must not be recorded")
+	})
+	t.Run("after-interval", func(t *testing.T) {
+		render(0, true)
+		events := list()
+		require.Len(t, events, 2)
+		require.Equal(t, int32(2), events["local"].Count)
+		require.Equal(t, int32(2), events["expired-grant"].Count)
+	})
+}
+
+func TestEventName(t *testing.T) {
+	newEvent := func(name, message string) *corev1.Event {
+		return &corev1.Event{
+			InvolvedObject: corev1.ObjectReference{Name: name, UID: "uid"},
+			Reason:         EventReasonReplicated,
+			Message:        message,
+		}
+	}
+
+	require.Regexp(t, `^foo\.[0-9a-f]{16}$`, eventName(newEvent("foo", "bar")))
+	require.Equal(t, eventName(newEvent("foo", "bar")), eventName(newEvent("foo", "bar")))
+	require.NotEqual(t, eventName(newEvent("foo", "bar")), eventName(newEvent("foo", "baz")))
+
+	long := strings.Repeat("a", 253)
+	require.Len(t, eventName(newEvent(long, "bar")), 253)
+	require.NotEqual(t, eventName(newEvent(long, "bar")), eventName(newEvent(long, "baz")))
+}
@@ -0,0 +1,194 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+)
+
+// IndexFieldManager owns index labels written by Sync
+const IndexFieldManager = "argocd-cmp-replicator-sync"
+
+// IndexLabel is the label a secret carries for each namespace it is explicitly allowed to be replicated to.
+// Namespace is hashed as it may be longer than label name allows.
+func IndexLabel(namespace string) string {
+	sum := sha256.Sum256([]byte(namespace))
+	return types.ReplicatorIndexLabelPrefix + hex.EncodeToString(sum[:8])
+}
+
+// expectedIndexLabels derives index labels from the allowed namespaces annotation
+func expectedIndexLabels(secret corev1.Secret) map[string]string {
+	expected := map[string]string{}
+	allowedNamespacesStr := secret.Annotations[types.ReplicatorAnnotationAllowedNamespaces]
+	switch allowedNamespacesStr {
+	case "", "-":
+	case "*":
+		expected[types.ReplicatorIndexLabelWildcard] = "true"
+	default:
+		for _, namespace := range allowedNamespaceList(allowedNamespacesStr) {
+			if namespace != "" {
+				expected[IndexLabel(namespace)] = "true"
+			}
+		}
+	}
+	return expected
+}
+
+// indexLabels returns index labels the secret currently has
+func indexLabels(secret corev1.Secret) map[string]string {
+	current := map[string]string{}
+	for label, value := range secret.Labels {
+		if label == types.ReplicatorIndexLabelWildcard || strings.HasPrefix(label, types.ReplicatorIndexLabelPrefix) {
+			current[label] = value
+		}
+	}
+	return current
+}
+
+// indexAgrees is true when index labels match the annotations.
+// Index is only used to narrow down the List, replication is always decided by the annotations,
+// so a stale index can hide a secret but never widen access to it.
+func indexAgrees(secret corev1.Secret) bool {
+	return maps.Equal(indexLabels(secret), expectedIndexLabels(secret))
+}
+
+// ListIndexedCandidates lists only secrets that may be replicated to the namespace according to the index:
+// the ones in the namespace itself, the ones allowed to any namespace and the ones listing this namespace.
+// Without ClientOptions.UseIndex it is the same as ListFilteredCandidates.
+func (c *Client) ListIndexedCandidates(ctx context.Context, labelSelector, namespace string, filter Filter) ([]corev1.Secret, error) {
+	if !c.Options.UseIndex {
+		return c.ListFilteredCandidates(ctx, labelSelector, filter)
+	}
+
+	candidates := []corev1.Secret{}
+	keepOnce := &onceFilter{Filter: filter, seen: map[string]bool{}, attempt: map[string]bool{}}
+
+	queries := []struct {
+		namespace     string
+		labelSelector string
+	}{
+		{namespace, labelSelector},
+		{"", fmt.Sprintf("%s,%s=%s", labelSelector, types.ReplicatorIndexLabelWildcard, "true")},
+		{"", fmt.Sprintf("%s,%s=%s", labelSelector, IndexLabel(namespace), "true")},
+	}
+	for _, query := range queries {
+		secrets, err := c.listAllPages(ctx, query.namespace, query.labelSelector, keepOnce)
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug("Listed indexed secrets", "namespace", query.namespace, "labelSelector", query.labelSelector, "kept", len(secrets))
+		candidates = append(candidates, secrets...)
+	}
+	return candidates, nil
+}
+
+// onceFilter only passes each secret to the filter once, a secret may match more than one index query.
+// Secrets seen in a listing attempt that started over are forgotten, they are listed again.
+type onceFilter struct {
+	Filter
+	seen    map[string]bool
+	attempt map[string]bool
+}
+
+func (f *onceFilter) Keep(secret corev1.Secret) (bool, error) {
+	key := secret.Namespace + "/" + secret.Name
+	if f.seen[key] || f.attempt[key] {
+		return false, nil
+	}
+	f.attempt[key] = true
+	return f.Filter.Keep(secret)
+}
+
+func (f *onceFilter) Commit() {
+	maps.Copy(f.seen, f.attempt)
+	clear(f.attempt)
+	f.Filter.Commit()
+}
+
+func (f *onceFilter) Rollback() {
+	clear(f.attempt)
+	f.Filter.Rollback()
+}
+
+// SyncResult is a change Sync made or would make to index labels of a secret
+type SyncResult struct {
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	Add       map[string]string `json:"add,omitempty"`
+	Remove    []string          `json:"remove,omitempty"`
+}
+
+// Sync sets index labels on all replicable secrets to match their annotations
+func (c *Client) Sync(ctx context.Context, dryRun bool) ([]SyncResult, error) {
+	results := []SyncResult{}
+	seen := map[string]bool{}
+	for _, label := range []string{types.ReplicatorLabel, types.ReplicatorLabelAlternative} {
+		secrets, err := c.ListCandidates(ctx, fmt.Sprintf("%s=%s", label, "true"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, secret := range secrets {
+			key := secret.Namespace + "/" + secret.Name
+			if seen[key] || indexAgrees(secret) {
+				continue
+			}
+			seen[key] = true
+
+			current, expected := indexLabels(secret), expectedIndexLabels(secret)
+			result := SyncResult{
+				Namespace: secret.Namespace,
+				Name:      secret.Name,
+				Add:       map[string]string{},
+			}
+			patchLabels := map[string]interface{}{}
+			for label, value := range expected {
+				if current[label] != value {
+					result.Add[label] = value
+					patchLabels[label] = value
+				}
+			}
+			for label := range current {
+				if _, ok := expected[label]; !ok {
+					result.Remove = append(result.Remove, label)
+					patchLabels[label] = nil
+				}
+			}
+			results = append(results, result)
+
+			if dryRun {
+				continue
+			}
+
+			patch, err := json.Marshal(map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"labels": patchLabels,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			err = c.Options.Retry.do(ctx, "patch secret index", func() error {
+				_, err := c.CoreV1().Secrets(secret.Namespace).Patch(ctx, secret.Name, k8stypes.MergePatchType, patch, metav1.PatchOptions{
+					FieldManager: IndexFieldManager,
+				})
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			slog.Info("Synced secret index", "name", secret.Name, "namespace", secret.Namespace, "add", result.Add, "remove", result.Remove)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,141 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIndex(t *testing.T) {
+	newSecret := func(name, namespace, allowedNamespaces string, labels map[string]string) *corev1.Secret {
+		secret := newLabeledSecret(name, namespace, map[string]string{})
+		if allowedNamespaces != "" {
+			secret.Annotations[types.ReplicatorAnnotationAllowedNamespaces] = allowedNamespaces
+		}
+		for label, value := range labels {
+			secret.Labels[label] = value
+		}
+		return secret
+	}
+	newClient := func() *Client {
+		return &Client{
+			Interface: testClient.NewSimpleClientset(
+				newSecret("local", "my-test-namespace", "", nil),
+				newSecret("to-all", "other-namespace", "*", nil),
+				newSecret("to-list", "other-namespace", "foo,my-test-namespace", nil),
+				newSecret("to-other", "other-namespace", "foo", nil),
+				// Index says my-test-namespace, but annotation no longer does
+				newSecret("stale", "other-namespace", "foo", map[string]string{
+					IndexLabel("my-test-namespace"): "true",
+				}),
+			),
+			Options: ClientOptions{
+				UseIndex: true,
+			},
+		}
+	}
+	keepAll := KeepFunc(func(corev1.Secret) (bool, error) {
+		return true, nil
+	})
+	names := func(secrets []corev1.Secret) []string {
+		result := []string{}
+		for _, secret := range secrets {
+			result = append(result, secret.Name)
+		}
+		return result
+	}
+
+	t.Run("index-label", func(t *testing.T) {
+		require.Equal(t, IndexLabel("my-test-namespace"), IndexLabel("my-test-namespace"))
+		require.NotEqual(t, IndexLabel("my-test-namespace"), IndexLabel("foo"))
+		require.LessOrEqual(t, len(IndexLabel("my-test-namespace"))-len("plumber-cd.github.io/"), 63)
+	})
+	t.Run("sync", func(t *testing.T) {
+		client := newClient()
+
+		results, err := client.Sync(context.TODO(), true)
+		require.NoError(t, err)
+		require.Len(t, results, 4)
+
+		secret, err := client.CoreV1().Secrets("other-namespace").Get(context.TODO(), "to-all", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Empty(t, indexLabels(*secret), "dry run must not patch")
+
+		results, err = client.Sync(context.TODO(), false)
+		require.NoError(t, err)
+		require.Len(t, results, 4)
+		for _, result := range results {
+			if result.Name == "stale" {
+				require.Equal(t, []string{IndexLabel("my-test-namespace")}, result.Remove)
+				require.Equal(t, map[string]string{IndexLabel("foo"): "true"}, result.Add)
+			}
+		}
+
+		secret, err = client.CoreV1().Secrets("other-namespace").Get(context.TODO(), "to-list", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{
+			IndexLabel("foo"):               "true",
+			IndexLabel("my-test-namespace"): "true",
+		}, indexLabels(*secret))
+		require.Equal(t, "true", secret.Labels[types.ReplicatorLabel])
+
+		results, err = client.Sync(context.TODO(), false)
+		require.NoError(t, err)
+		require.Empty(t, results)
+	})
+	t.Run("indexed-list", func(t *testing.T) {
+		client := newClient()
+
+		secrets, err := client.ListIndexedCandidates(context.TODO(), types.ReplicatorLabel+"=true", "my-test-namespace", keepAll)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"local", "stale"}, names(secrets))
+
+		_, err = client.Sync(context.TODO(), false)
+		require.NoError(t, err)
+
+		secrets, err = client.ListIndexedCandidates(context.TODO(), types.ReplicatorLabel+"=true", "my-test-namespace", keepAll)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"local", "to-all", "to-list"}, names(secrets))
+	})
+	t.Run("stale-index-does-not-widen-access", func(t *testing.T) {
+		client := newClient()
+
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"local"}, names(secrets.Items))
+	})
+	t.Run("without-index", func(t *testing.T) {
+		client := newClient()
+		client.Options.UseIndex = false
+
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"local", "to-all", "to-list"}, names(secrets.Items))
+	})
+	t.Run("lint", func(t *testing.T) {
+		client := newClient()
+
+		findings, err := client.Lint(context.TODO(), LintOptions{})
+		require.NoError(t, err)
+		stale := []string{}
+		for _, finding := range findings {
+			if finding.Reason == LintReasonStaleIndex {
+				stale = append(stale, finding.Name)
+			}
+		}
+		require.ElementsMatch(t, []string{"to-all", "to-list", "to-other", "stale"}, stale)
+
+		client.Options.UseIndex = false
+		findings, err = client.Lint(context.TODO(), LintOptions{})
+		require.NoError(t, err)
+		require.Len(t, findings, 1)
+		require.Equal(t, "stale", findings[0].Name)
+	})
+}
@@ -0,0 +1,154 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	LintReasonInvalidWindow        = "InvalidWindow"
+	LintReasonExpired              = "Expired"
+	LintReasonExpiring             = "Expiring"
+	LintReasonStale                = "Stale"
+	LintReasonUnknownAge           = "UnknownAge"
+	LintReasonStaleIndex           = "StaleIndex"
+	LintReasonInvalidArgoCDOptions = "InvalidArgoCDOptions"
+)
+
+type LintOptions struct {
+	// ExpiryHorizon is how far ahead to look for expiring grants
+	ExpiryHorizon time.Duration
+}
+
+type LintFinding struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+	Message   string `json:"message"`
+}
+
+// Lint checks all replicable secrets in the cluster regardless of where they would be replicated to.
+// A secret with both labels is only checked once.
+func (c *Client) Lint(ctx context.Context, options LintOptions) ([]LintFinding, error) {
+	findings := []LintFinding{}
+	seen := map[SecretReference]bool{}
+	for _, label := range []string{types.ReplicatorLabel, types.ReplicatorLabelAlternative} {
+		secrets, err := c.ListCandidates(ctx, fmt.Sprintf("%s=%s", label, "true"))
+		if err != nil {
+			return nil, err
+		}
+
+		slog.Debug("Listed labeled secrets for lint", "label", label, "count", len(secrets))
+
+		for _, secret := range secrets {
+			ref := SecretReference{Namespace: secret.Namespace, Name: secret.Name}
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+
+			findings = append(findings, lintGrantWindow(secret, options.ExpiryHorizon)...)
+			findings = append(findings, c.Policy.lintStaleness(secret)...)
+			findings = append(findings, c.lintIndex(secret)...)
+			findings = append(findings, lintArgoCDOptions(secret)...)
+		}
+	}
+	return findings, nil
+}
+
+func lintGrantWindow(secret corev1.Secret, horizon time.Duration) []LintFinding {
+	finding := LintFinding{
+		Namespace: secret.Namespace,
+		Name:      secret.Name,
+	}
+
+	_, notAfter, err := grantWindow(secret)
+	if err != nil {
+		finding.Reason = LintReasonInvalidWindow
+		finding.Message = err.Error()
+		return []LintFinding{finding}
+	}
+	if notAfter.IsZero() {
+		return nil
+	}
+
+	t := now()
+	if !t.Before(notAfter) {
+		finding.Reason = LintReasonExpired
+		finding.Message = fmt.Sprintf("grant expired at %s", notAfter.Format(time.RFC3339))
+		return []LintFinding{finding}
+	}
+	if notAfter.Sub(t) <= horizon {
+		finding.Reason = LintReasonExpiring
+		finding.Message = fmt.Sprintf("grant expires at %s", notAfter.Format(time.RFC3339))
+		return []LintFinding{finding}
+	}
+	return nil
+}
+
+func (p Policy) lintStaleness(secret corev1.Secret) []LintFinding {
+	finding := LintFinding{
+		Namespace: secret.Namespace,
+		Name:      secret.Name,
+	}
+
+	maxAge, err := p.maxAge(secret)
+	if err != nil {
+		finding.Reason = LintReasonUnknownAge
+		finding.Message = err.Error()
+		return []LintFinding{finding}
+	}
+	if maxAge == 0 {
+		return nil
+	}
+
+	stale, rotated, err := p.isStale(secret)
+	if err != nil {
+		finding.Reason = LintReasonUnknownAge
+		finding.Message = err.Error()
+		return []LintFinding{finding}
+	}
+	if rotated.IsZero() {
+		finding.Reason = LintReasonUnknownAge
+		finding.Message = "last rotation time is unknown"
+		return []LintFinding{finding}
+	}
+	if stale {
+		finding.Reason = LintReasonStale
+		finding.Message = fmt.Sprintf("last rotated at %s, max age is %s", rotated.Format(time.RFC3339), maxAge)
+		return []LintFinding{finding}
+	}
+	return nil
+}
+
+// lintIndex reports secrets whose index labels disagree with the annotations.
+// Secrets without index labels are only reported when the index is in use, otherwise the index is simply not there.
+func (c *Client) lintIndex(secret corev1.Secret) []LintFinding {
+	if indexAgrees(secret) || (!c.Options.UseIndex && len(indexLabels(secret)) == 0) {
+		return nil
+	}
+	return []LintFinding{{
+		Namespace: secret.Namespace,
+		Name:      secret.Name,
+		Reason:    LintReasonStaleIndex,
+		Message:   "index labels do not match allowed namespaces annotation, run sync",
+	}}
+}
+
+// lintArgoCDOptions reports options ArgoCD does not know, they fail the render of every namespace the secret is replicated to
+func lintArgoCDOptions(secret corev1.Secret) []LintFinding {
+	if _, err := argoCDOptions(secret.Annotations); err != nil {
+		return []LintFinding{{
+			Namespace: secret.Namespace,
+			Name:      secret.Name,
+			Reason:    LintReasonInvalidArgoCDOptions,
+			Message:   err.Error(),
+		}}
+	}
+	return nil
+}
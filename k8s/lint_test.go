@@ -0,0 +1,69 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLint(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	}
+	t.Cleanup(func() {
+		now = time.Now
+	})
+
+	newSecret := func(name, label, notAfter string) *corev1.Secret {
+		secret := newLabeledSecret(name, "some-namespace", map[string]string{})
+		secret.Labels = map[string]string{
+			label: "true",
+		}
+		if notAfter != "" {
+			secret.Annotations[types.ReplicatorAnnotationNotAfter] = notAfter
+		}
+		return secret
+	}
+	bothLabels := newSecret("expired-both-labels", types.ReplicatorLabel, "2024-02-01T00:00:00Z")
+	bothLabels.Labels[types.ReplicatorLabelAlternative] = "true"
+
+	_client := testClient.NewSimpleClientset(
+		newSecret("no-window", types.ReplicatorLabel, ""),
+		newSecret("far-future", types.ReplicatorLabel, "2025-01-01T00:00:00Z"),
+		newSecret("expiring", types.ReplicatorLabel, "2024-03-05T00:00:00Z"),
+		newSecret("expiring-alternative", types.ReplicatorLabelAlternative, "2024-03-05T00:00:00Z"),
+		newSecret("expired", types.ReplicatorLabel, "2024-02-01T00:00:00Z"),
+		newSecret("invalid", types.ReplicatorLabel, "2024-02-01"),
+		newSecret("not-labeled", "foo", "2024-02-01T00:00:00Z"),
+		bothLabels,
+	)
+
+	client := Client{
+		Interface: _client,
+	}
+
+	findings, err := client.Lint(context.TODO(), LintOptions{
+		ExpiryHorizon: 7 * 24 * time.Hour,
+	})
+	require.NoError(t, err)
+	require.Len(t, findings, 5)
+
+	reasons := map[string]string{}
+	for _, finding := range findings {
+		reasons[finding.Name] = finding.Reason
+	}
+	require.Equal(t, map[string]string{
+		"expiring":             LintReasonExpiring,
+		"expiring-alternative": LintReasonExpiring,
+		"expired":              LintReasonExpired,
+		"expired-both-labels":  LintReasonExpired,
+		"invalid":              LintReasonInvalidWindow,
+	}, reasons)
+}
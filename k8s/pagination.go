@@ -0,0 +1,97 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/tracing"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultPageSize is how many secrets are listed at once unless ClientOptions.PageSize says otherwise
+const DefaultPageSize = 500
+
+// maxListRestarts is how many times listing starts over when the continue token expires
+const maxListRestarts = 3
+
+// ListFilteredCandidates implements FilteringSource for the cluster the client is connected to.
+// Secrets are listed in pages and only the ones to keep are held in memory.
+// If the continue token expires (410 Gone), listing starts over, as the pages already seen may not be consistent with the new ones.
+// The filter is rolled back then, it is only committed once the listing is over.
+func (c *Client) ListFilteredCandidates(ctx context.Context, labelSelector string, filter Filter) ([]corev1.Secret, error) {
+	return c.listAllPages(ctx, "", labelSelector, filter)
+}
+
+func (c *Client) listAllPages(ctx context.Context, namespace, labelSelector string, filter Filter) ([]corev1.Secret, error) {
+	pageSize := c.Options.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize < 0 {
+		pageSize = 0
+	}
+
+	for restarts := 0; ; restarts++ {
+		kept, err := c.listPages(ctx, namespace, labelSelector, pageSize, filter)
+		if err == nil {
+			filter.Commit()
+			return kept, nil
+		}
+		if (!apierrors.IsResourceExpired(err) && !apierrors.IsGone(err)) || restarts >= maxListRestarts {
+			// Secrets denied before the render failed must still be recorded
+			filter.Commit()
+			return nil, err
+		}
+		filter.Rollback()
+		slog.Warn("List continue token expired, starting over", "restarts", restarts+1, "err", err)
+	}
+}
+
+func (c *Client) listPages(ctx context.Context, namespace, labelSelector string, pageSize int64, filter Filter) ([]corev1.Secret, error) {
+	kept := []corev1.Secret{}
+	continueToken := ""
+	for page := 1; ; page++ {
+		var secrets *corev1.SecretList
+		pageCtx, span := tracing.Tracer().Start(ctx, "list page", trace.WithAttributes(tracing.AttributePage.Int(page)))
+		err := c.Options.Retry.do(pageCtx, fmt.Sprintf("list secrets page %d", page), func() (err error) {
+			secrets, err = c.CoreV1().Secrets(namespace).List(pageCtx, metav1.ListOptions{
+				LabelSelector: labelSelector,
+				Limit:         pageSize,
+				Continue:      continueToken,
+			})
+			return err
+		})
+		tracing.End(span, err)
+		if err != nil {
+			return nil, &SourceError{Source: c.Describe(), Err: err}
+		}
+
+		for i := range secrets.Items {
+			// Never trust this annotation on the source, it is only set by the plugin itself
+			delete(secrets.Items[i].Annotations, types.ReplicatorAnnotationFromCluster)
+			if c.ClusterName != "" {
+				if secrets.Items[i].Annotations == nil {
+					secrets.Items[i].Annotations = map[string]string{}
+				}
+				secrets.Items[i].Annotations[types.ReplicatorAnnotationFromCluster] = c.ClusterName
+			}
+		}
+		keptFromPage, err := match(ctx, secrets.Items, filter)
+		if err != nil {
+			return nil, err
+		}
+		kept = append(kept, keptFromPage...)
+
+		slog.Debug("Listed page of secrets", "page", page, "count", len(secrets.Items), "kept", len(kept))
+
+		continueToken = secrets.Continue
+		if continueToken == "" {
+			return kept, nil
+		}
+	}
+}
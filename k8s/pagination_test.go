@@ -0,0 +1,259 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+// pagedClientset serves generated secrets with Limit and Continue, which the fake clientset ignores.
+// Secrets are generated as pages are requested, so the fake itself does not hold them in memory.
+type pagedClientset struct {
+	*testClient.Clientset
+	count    int
+	generate func(i int) corev1.Secret
+	// fail if set, is called before each page and may return an error instead
+	fail  func(call int, options metav1.ListOptions) error
+	calls []metav1.ListOptions
+	// onPage if set, is called after each page is generated
+	onPage func()
+}
+
+func (p *pagedClientset) CoreV1() corev1client.CoreV1Interface {
+	return pagedCoreV1{p.Clientset.CoreV1(), p}
+}
+
+type pagedCoreV1 struct {
+	corev1client.CoreV1Interface
+	p *pagedClientset
+}
+
+func (c pagedCoreV1) Secrets(namespace string) corev1client.SecretInterface {
+	return pagedSecrets{c.CoreV1Interface.Secrets(namespace), c.p}
+}
+
+type pagedSecrets struct {
+	corev1client.SecretInterface
+	p *pagedClientset
+}
+
+func (s pagedSecrets) List(ctx context.Context, options metav1.ListOptions) (*corev1.SecretList, error) {
+	s.p.calls = append(s.p.calls, options)
+	if s.p.fail != nil {
+		if err := s.p.fail(len(s.p.calls), options); err != nil {
+			return nil, err
+		}
+	}
+
+	selector, err := labels.Parse(options.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	start := 0
+	if options.Continue != "" {
+		if start, err = strconv.Atoi(options.Continue); err != nil {
+			return nil, err
+		}
+	}
+
+	list := &corev1.SecretList{}
+	i := start
+	for ; i < s.p.count && (options.Limit == 0 || int64(len(list.Items)) < options.Limit); i++ {
+		secret := s.p.generate(i)
+		if selector.Matches(labels.Set(secret.Labels)) {
+			list.Items = append(list.Items, secret)
+		}
+	}
+	if i < s.p.count {
+		list.Continue = strconv.Itoa(i)
+	}
+	if s.p.onPage != nil {
+		s.p.onPage()
+	}
+	return list, nil
+}
+
+// generateSecret makes one in a hundred secrets replicable to my-test-namespace
+func generateSecret(i int) corev1.Secret {
+	namespace := fmt.Sprintf("namespace-%d", i%100)
+	if i%100 == 0 {
+		namespace = "my-test-namespace"
+	}
+	return corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("secret-%d", i),
+			Namespace: namespace,
+			Labels: map[string]string{
+				types.ReplicatorLabel: "true",
+			},
+		},
+		Data: map[string][]byte{
+			"key": make([]byte, 1024),
+		},
+	}
+}
+
+func TestPagination(t *testing.T) {
+	newClient := func(pageSize int64) (*Client, *pagedClientset) {
+		clientset := &pagedClientset{
+			Clientset: testClient.NewSimpleClientset(),
+			count:     1050,
+			generate:  generateSecret,
+		}
+		return &Client{
+			Interface: clientset,
+			Options: ClientOptions{
+				PageSize: pageSize,
+			},
+		}, clientset
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		client, clientset := newClient(100)
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		require.Len(t, secrets.Items, 11)
+		require.Len(t, clientset.calls, 11)
+		for _, call := range clientset.calls {
+			require.Equal(t, int64(100), call.Limit)
+			require.Equal(t, types.ReplicatorLabel+"=true", call.LabelSelector)
+		}
+		require.Equal(t, "", clientset.calls[0].Continue)
+		require.Equal(t, "100", clientset.calls[1].Continue)
+	})
+	t.Run("default-page-size", func(t *testing.T) {
+		client, clientset := newClient(0)
+		_, err := client.ListCandidates(context.TODO(), "")
+		require.NoError(t, err)
+		require.Equal(t, int64(DefaultPageSize), clientset.calls[0].Limit)
+		require.Len(t, clientset.calls, 3)
+	})
+	t.Run("unpaginated", func(t *testing.T) {
+		client, clientset := newClient(-1)
+		secrets, err := client.ListCandidates(context.TODO(), "")
+		require.NoError(t, err)
+		require.Len(t, secrets, 1050)
+		require.Len(t, clientset.calls, 1)
+		require.Equal(t, int64(0), clientset.calls[0].Limit)
+	})
+	t.Run("continue-expired", func(t *testing.T) {
+		client, clientset := newClient(100)
+		clientset.fail = func(call int, options metav1.ListOptions) error {
+			if call == 4 {
+				return apierrors.NewResourceExpired("continue token is too old")
+			}
+			return nil
+		}
+		// Pages seen before listing started over must not be recorded twice
+		stats := &Stats{}
+		client.Stats = stats
+		decisions := map[string]int{}
+		client.OnDecision = func(secret corev1.Secret, decision Decision) {
+			require.Nil(t, secret.Data)
+			decisions[decision.Decision]++
+		}
+
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		require.Len(t, secrets.Items, 11)
+		require.Equal(t, "", clientset.calls[4].Continue)
+		require.Len(t, clientset.calls, 4+11)
+		require.Equal(t, map[string]int{
+			DecisionReplicated: 11,
+			DecisionSkipped:    1039,
+		}, decisions)
+		require.Equal(t, map[string]int{SkipReasonNamespace: 1039}, stats.Skipped)
+	})
+	t.Run("continue-expired-index", func(t *testing.T) {
+		client, clientset := newClient(100)
+		client.Options.UseIndex = true
+		clientset.fail = func(call int, options metav1.ListOptions) error {
+			if call == 4 {
+				return apierrors.NewResourceExpired("continue token is too old")
+			}
+			return nil
+		}
+
+		// Secrets seen before listing started over are listed again, not dropped as duplicates
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		require.Len(t, secrets.Items, 11)
+	})
+	t.Run("continue-keeps-expiring", func(t *testing.T) {
+		client, clientset := newClient(100)
+		clientset.fail = func(call int, options metav1.ListOptions) error {
+			if options.Continue != "" {
+				return apierrors.NewResourceExpired("continue token is too old")
+			}
+			return nil
+		}
+
+		_, err := client.ListCandidates(context.TODO(), "")
+		require.True(t, apierrors.IsResourceExpired(err))
+		require.Len(t, clientset.calls, 2*(maxListRestarts+1))
+	})
+}
+
+// BenchmarkGetLabeledSecrets reports peak heap growth during the render,
+// which stays flat with pagination as the number of secrets grows
+func BenchmarkGetLabeledSecrets(b *testing.B) {
+	heapInUse := func() uint64 {
+		runtime.GC()
+		stats := runtime.MemStats{}
+		runtime.ReadMemStats(&stats)
+		return stats.HeapAlloc
+	}
+
+	for _, pageSize := range []int64{DefaultPageSize, -1} {
+		for _, count := range []int{1000, 10000, 50000} {
+			b.Run(fmt.Sprintf("page-size=%d/secrets=%d", pageSize, count), func(b *testing.B) {
+				peak := uint64(0)
+				clientset := &pagedClientset{
+					Clientset: testClient.NewSimpleClientset(),
+					count:     count,
+					generate:  generateSecret,
+					onPage: func() {
+						peak = max(peak, heapInUse())
+					},
+				}
+				client := &Client{
+					Interface: clientset,
+					Options: ClientOptions{
+						PageSize: pageSize,
+					},
+				}
+
+				growth := uint64(0)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					before := heapInUse()
+					peak = before
+					secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+					if err != nil {
+						b.Fatal(err)
+					}
+					if len(secrets.Items) != count/100 {
+						b.Fatalf("expected %d secrets, got %d", count/100, len(secrets.Items))
+					}
+					growth = max(growth, peak-before)
+				}
+				b.ReportMetric(float64(growth)/(1<<20), "peak-heap-MiB")
+			})
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package k8s
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/grants"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// grantLabels and grantAnnotations are the fields that decide whether and where the secret is replicated
+var grantLabels = []string{
+	types.ReplicatorLabel,
+	types.ReplicatorLabelAlternative,
+}
+var grantAnnotations = []string{
+	types.ReplicatorAnnotationAllowedNamespaces,
+	types.ReplicatorAnnotationReplicatedName,
+	types.ReplicatorAnnotationGrantSignature,
+	types.ReplicatorAnnotationNotBefore,
+	types.ReplicatorAnnotationNotAfter,
+}
+
+// Policy is operator-defined set of rules on top of what secret owners allow with annotations.
+type Policy struct {
+	// TrustedGrantKeys if set, any grant beyond implicit replication must be signed by one of these keys
+	TrustedGrantKeys []ed25519.PublicKey
+	// TrustedFieldManagers if set, grant labels and annotations must only be managed by these field managers
+	TrustedFieldManagers []string
+	// InvalidSecrets is what to do with secrets that fail ValidateSecret - InvalidSecretsFail, InvalidSecretsSkip or InvalidSecretsWarn (default)
+	InvalidSecrets string
+	// CertExpiryWarning is how long before the earliest certificate expires to start warning
+	CertExpiryWarning time.Duration
+	// RefuseExpiredCerts if set, fails the render when a replicated secret has expired certificate
+	RefuseExpiredCerts bool
+	// MaxSecretAge is how long a secret may go without rotation before it is stale, zero disables the check
+	MaxSecretAge time.Duration
+	// StaleSecrets is what to do with stale secrets - StaleSecretsMark (default) or StaleSecretsExclude
+	StaleSecrets string
+}
+
+func (p Policy) allowGrant(secret corev1.Secret) bool {
+	if len(p.TrustedGrantKeys) == 0 {
+		return true
+	}
+
+	grant := grants.FromSecret(secret)
+	if grant.IsEmpty() {
+		return true
+	}
+
+	if err := grants.VerifySecret(secret, p.TrustedGrantKeys); err != nil {
+		slog.Warn(
+			"Skipped secret with untrusted grant",
+			"name", secret.Name,
+			"namespace", secret.Namespace,
+			"err", err,
+		)
+		return false
+	}
+
+	slog.Debug(
+		"Verified grant signature",
+		"name", secret.Name,
+		"namespace", secret.Namespace,
+	)
+	return true
+}
+
+func (p Policy) allowFieldManagers(secret corev1.Secret) bool {
+	if len(p.TrustedFieldManagers) == 0 {
+		return true
+	}
+
+	managers, err := grantFieldManagers(secret)
+	if err != nil {
+		slog.Warn(
+			"Skipped secret with unreadable managed fields",
+			"name", secret.Name,
+			"namespace", secret.Namespace,
+			"err", err,
+		)
+		return false
+	}
+
+	if len(managers) == 0 {
+		slog.Warn(
+			"Skipped secret without managed fields for the grant",
+			"name", secret.Name,
+			"namespace", secret.Namespace,
+		)
+		return false
+	}
+
+	for _, manager := range managers {
+		if !slices.Contains(p.TrustedFieldManagers, manager) {
+			slog.Warn(
+				"Skipped secret with grant set by untrusted field manager",
+				"name", secret.Name,
+				"namespace", secret.Namespace,
+				"manager", manager,
+				"managers", managers,
+			)
+			return false
+		}
+	}
+
+	slog.Debug(
+		"Grant set by trusted field managers",
+		"name", secret.Name,
+		"namespace", secret.Namespace,
+		"managers", managers,
+	)
+	return true
+}
+
+// grantFieldManagers returns all field managers that own any of the grant labels or annotations.
+func grantFieldManagers(secret corev1.Secret) ([]string, error) {
+	managers := []string{}
+	for _, entry := range secret.ManagedFields {
+		if entry.FieldsV1 == nil {
+			continue
+		}
+
+		fields := struct {
+			Metadata struct {
+				Labels      map[string]json.RawMessage `json:"f:labels"`
+				Annotations map[string]json.RawMessage `json:"f:annotations"`
+			} `json:"f:metadata"`
+		}{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			return nil, err
+		}
+
+		owns := false
+		for _, label := range grantLabels {
+			if _, ok := fields.Metadata.Labels["f:"+label]; ok {
+				owns = true
+			}
+		}
+		for _, annotation := range grantAnnotations {
+			if _, ok := fields.Metadata.Annotations["f:"+annotation]; ok {
+				owns = true
+			}
+		}
+
+		if owns && !slices.Contains(managers, entry.Manager) {
+			managers = append(managers, entry.Manager)
+		}
+	}
+	return managers, nil
+}
@@ -0,0 +1,102 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	argocdv1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GetApplication returns the Application by name as ArgoCD sets ARGOCD_APP_NAME, which is namespace_name
+// for Applications outside of the ArgoCD namespace. Neither namespaces nor names can have an underscore.
+// It returns nil if the Application does not exist, there is nothing it manages yet.
+func (c *Client) GetApplication(ctx context.Context, namespace, name string) (*argocdv1alpha1.Application, error) {
+	if c.ArgoCD == nil {
+		return nil, errors.New("client is not connected to ArgoCD")
+	}
+	if appNamespace, appName, ok := strings.Cut(name, "_"); ok {
+		namespace, name = appNamespace, appName
+	}
+
+	var app *argocdv1alpha1.Application
+	err := c.Options.Retry.do(ctx, "get application", func() (err error) {
+		app, err = c.ArgoCD.ArgoprojV1alpha1().Applications(namespace).Get(ctx, name, metav1.GetOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		slog.Warn("Application not found, nothing to protect from removal", "namespace", namespace, "name", name)
+		return nil, nil
+	}
+	return app, err
+}
+
+// ManagedSecrets returns names of Secrets in the namespace the Application manages according to its status
+func ManagedSecrets(app *argocdv1alpha1.Application, namespace string) []string {
+	if app == nil {
+		return []string{}
+	}
+	managed := []string{}
+	for _, resource := range app.Status.Resources {
+		// Hooks are deleted by ArgoCD on its own
+		if resource.Group != "" || resource.Kind != "Secret" || resource.Namespace != namespace || resource.Hook {
+			continue
+		}
+		managed = append(managed, resource.Name)
+	}
+	slices.Sort(managed)
+	return managed
+}
+
+// Removals returns managed Secrets that the render would remove, replicas of retired secrets are not counted
+func Removals(managed []string, secrets *corev1.SecretList, retired []string) []string {
+	rendered := map[string]bool{}
+	for _, secret := range secrets.Items {
+		rendered[ReplicaName(secret)] = true
+	}
+
+	removals := []string{}
+	for _, name := range managed {
+		if !rendered[name] && !slices.Contains(retired, name) {
+			removals = append(removals, name)
+		}
+	}
+	return removals
+}
+
+// KeepReplica returns a copy of the replica from the last good render to render it again.
+// The copy is annotated with the time it was first kept, and is not kept for longer than maxStaleness,
+// as storing the render with it would otherwise keep it forever.
+func KeepReplica(replica corev1.Secret, renderedAt time.Time, maxStaleness time.Duration, now time.Time) (corev1.Secret, error) {
+	keptSince := renderedAt
+	if v := replica.Annotations[types.ReplicatorAnnotationKeptSince]; v != "" {
+		var err error
+		if keptSince, err = time.Parse(time.RFC3339, v); err != nil {
+			return corev1.Secret{}, fmt.Errorf("invalid %s of %s: %w", types.ReplicatorAnnotationKeptSince, replica.Name, err)
+		}
+	}
+	if now.Sub(keptSince) > maxStaleness {
+		return corev1.Secret{}, fmt.Errorf("%w: copy of %s is kept since %s, longer than %s", ErrRemovalDenied, replica.Name, keptSince.UTC().Format(time.RFC3339), maxStaleness)
+	}
+
+	replica = *replica.DeepCopy()
+	if replica.Annotations == nil {
+		replica.Annotations = map[string]string{}
+	}
+	replica.Annotations[types.ReplicatorAnnotationKeptSince] = keptSince.UTC().Format(time.RFC3339)
+	return replica, nil
+}
+
+// Kept records the decision to render a replica kept from the last good render.
+// There is no source secret behind it, so the decision names the replica.
+func (c *Client) Kept(replica corev1.Secret) {
+	c.decided(replica, Decision{Decision: DecisionKept})
+}
@@ -0,0 +1,140 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	argocdv1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	argocdfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRemovals(t *testing.T) {
+	allowed := func(annotations map[string]string) map[string]string {
+		annotations[types.ReplicatorAnnotationAllowedNamespaces] = "my-test-namespace"
+		return annotations
+	}
+	app := &argocdv1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-app",
+			Namespace: "argocd",
+		},
+		Status: argocdv1alpha1.ApplicationStatus{
+			Resources: []argocdv1alpha1.ResourceStatus{
+				{Kind: "Secret", Namespace: "my-test-namespace", Name: "kept-replicated-from-some-other-namespace"},
+				{Kind: "Secret", Namespace: "my-test-namespace", Name: "retired-replicated-from-some-other-namespace"},
+				{Kind: "Secret", Namespace: "my-test-namespace", Name: "unlabeled-replicated-from-some-other-namespace"},
+				{Kind: "Secret", Namespace: "my-test-namespace", Name: "hook", Hook: true},
+				{Kind: "Secret", Namespace: "another-namespace", Name: "elsewhere"},
+				{Group: "example.com", Kind: "Secret", Namespace: "my-test-namespace", Name: "not-core"},
+				{Kind: "ConfigMap", Namespace: "my-test-namespace", Name: "config"},
+			},
+		},
+	}
+
+	retired := []string{}
+	client := Client{
+		Interface: testClient.NewSimpleClientset(
+			newLabeledSecret("kept", "some-other-namespace", allowed(map[string]string{})),
+			newLabeledSecret("retired", "some-other-namespace", allowed(map[string]string{types.ReplicatorAnnotationRetire: "true"})),
+		),
+		ArgoCD: argocdfake.NewSimpleClientset(app),
+		Stats:  &Stats{},
+		OnDecision: func(secret corev1.Secret, decision Decision) {
+			if decision.Reason == ReasonRetired {
+				retired = append(retired, ReplicaName(secret))
+			}
+		},
+	}
+
+	secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+	require.NoError(t, err)
+	require.Len(t, secrets.Items, 1)
+	require.Equal(t, "kept", secrets.Items[0].Name)
+	require.Equal(t, []string{"retired-replicated-from-some-other-namespace"}, retired)
+	require.Equal(t, 1, client.Stats.Skipped[SkipReasonRetired])
+
+	t.Run("managed", func(t *testing.T) {
+		got, err := client.GetApplication(context.TODO(), "argocd", "my-app")
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"kept-replicated-from-some-other-namespace",
+			"retired-replicated-from-some-other-namespace",
+			"unlabeled-replicated-from-some-other-namespace",
+		}, ManagedSecrets(got, "my-test-namespace"))
+	})
+	t.Run("qualified-name", func(t *testing.T) {
+		got, err := client.GetApplication(context.TODO(), "some-namespace", "argocd_my-app")
+		require.NoError(t, err)
+		require.NotNil(t, got)
+	})
+	t.Run("not-found", func(t *testing.T) {
+		got, err := client.GetApplication(context.TODO(), "argocd", "new-app")
+		require.NoError(t, err)
+		require.Nil(t, got)
+		require.Empty(t, ManagedSecrets(got, "my-test-namespace"))
+	})
+	t.Run("removals", func(t *testing.T) {
+		managed := ManagedSecrets(app, "my-test-namespace")
+		require.Equal(t, []string{"unlabeled-replicated-from-some-other-namespace"}, Removals(managed, secrets, retired))
+		require.Equal(t, []string{
+			"retired-replicated-from-some-other-namespace",
+			"unlabeled-replicated-from-some-other-namespace",
+		}, Removals(managed, secrets, nil))
+		require.Empty(t, Removals([]string{}, secrets, retired))
+	})
+}
+
+func TestKeepReplica(t *testing.T) {
+	renderedAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	replica := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "gone-replicated-from-some-other-namespace",
+			Namespace: "my-test-namespace",
+			Annotations: map[string]string{
+				types.ReplicatorAnnotationFromNamespace: "some-other-namespace",
+			},
+		},
+		Data: map[string][]byte{
+			"key": []byte("value"),
+		},
+	}
+
+	kept, err := KeepReplica(replica, renderedAt, 24*time.Hour, renderedAt.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, "2026-01-01T00:00:00Z", kept.Annotations[types.ReplicatorAnnotationKeptSince])
+	require.NotContains(t, replica.Annotations, types.ReplicatorAnnotationKeptSince)
+
+	// The render it is kept in is stored again, it is still kept since the first time
+	kept, err = KeepReplica(kept, renderedAt.Add(20*time.Hour), 24*time.Hour, renderedAt.Add(21*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, "2026-01-01T00:00:00Z", kept.Annotations[types.ReplicatorAnnotationKeptSince])
+
+	_, err = KeepReplica(kept, renderedAt.Add(24*time.Hour), 24*time.Hour, renderedAt.Add(25*time.Hour))
+	require.ErrorIs(t, err, ErrRemovalDenied)
+
+	kept.Annotations[types.ReplicatorAnnotationKeptSince] = "yesterday"
+	_, err = KeepReplica(kept, renderedAt, 24*time.Hour, renderedAt)
+	require.Error(t, err)
+
+	decisions := []Decision{}
+	client := Client{
+		OnDecision: func(_ corev1.Secret, decision Decision) {
+			decisions = append(decisions, decision)
+		},
+	}
+	client.Kept(replica)
+	require.Equal(t, []Decision{{
+		Namespace: "my-test-namespace",
+		Name:      "gone-replicated-from-some-other-namespace",
+		Decision:  DecisionKept,
+		Digest:    Digest(replica),
+	}}, decisions)
+}
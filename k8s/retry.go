@@ -0,0 +1,57 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Retry is how API calls are retried on errors that may go away by themselves, like 429 or 5xx
+type Retry struct {
+	// Attempts in total, zero or one means calls are not retried
+	Attempts int
+	// Backoff before the first retry, it doubles after each attempt and up to the same amount of jitter is added
+	Backoff time.Duration
+	// MaxBackoff if set, caps the backoff
+	MaxBackoff time.Duration
+}
+
+// do calls fn until it succeeds, fails with an error that is not retryable, attempts run out or the context is done.
+// The error returned is classified, see ErrUnreachable and ErrForbidden.
+func (r Retry) do(ctx context.Context, op string, fn func() error) error {
+	backoff := r.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.Attempts || !retryable(err) {
+			return classify(err)
+		}
+
+		delay := wait.Jitter(backoff, 1.0)
+		if seconds, ok := apierrors.SuggestsClientDelay(err); ok && time.Duration(seconds)*time.Second > delay {
+			delay = time.Duration(seconds) * time.Second
+		}
+		if r.MaxBackoff > 0 && delay > r.MaxBackoff {
+			delay = r.MaxBackoff
+		}
+		slog.Warn(
+			"Retrying API call",
+			"op", op,
+			"attempt", attempt,
+			"delay", delay.String(),
+			"err", err,
+		)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %s: %w (last error: %v)", ErrUnreachable, op, ctx.Err(), err)
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,150 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFailingClient fails the first failures List calls with err
+func newFailingClient(failures int, err error, objects ...runtime.Object) (*Client, *int) {
+	clientset := testClient.NewSimpleClientset(objects...)
+	calls := 0
+	clientset.PrependReactor("list", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		if calls <= failures {
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+	return &Client{
+		Interface: clientset,
+		Options: ClientOptions{
+			Retry: Retry{
+				Attempts:   3,
+				Backoff:    time.Millisecond,
+				MaxBackoff: 10 * time.Millisecond,
+			},
+		},
+	}, &calls
+}
+
+func TestRetry(t *testing.T) {
+	labeled := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "labeled-secret",
+			Namespace: "my-test-namespace",
+			Labels: map[string]string{
+				types.ReplicatorLabel: "true",
+			},
+		},
+	}
+	notFound := apierrors.NewNotFound(corev1.Resource("secrets"), "")
+
+	t.Run("too-many-requests", func(t *testing.T) {
+		client, calls := newFailingClient(2, apierrors.NewTooManyRequests("slow down", 0), labeled)
+		secrets, err := client.ListCandidates(context.TODO(), "")
+		require.NoError(t, err)
+		require.Len(t, secrets, 1)
+		require.Equal(t, 3, *calls)
+	})
+	t.Run("attempts-run-out", func(t *testing.T) {
+		client, calls := newFailingClient(3, apierrors.NewServiceUnavailable("maintenance"), labeled)
+		_, err := client.ListCandidates(context.TODO(), "")
+		require.ErrorIs(t, err, ErrUnreachable)
+		require.Equal(t, "unreachable", ErrorReason(err))
+		require.Equal(t, 3, *calls)
+	})
+	t.Run("connection-refused", func(t *testing.T) {
+		client, calls := newFailingClient(5, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}, labeled)
+		_, err := client.ListCandidates(context.TODO(), "")
+		require.ErrorIs(t, err, ErrUnreachable)
+		require.Equal(t, 3, *calls)
+	})
+	t.Run("forbidden", func(t *testing.T) {
+		client, calls := newFailingClient(1, apierrors.NewForbidden(corev1.Resource("secrets"), "", errors.New("no RBAC")), labeled)
+		_, err := client.ListCandidates(context.TODO(), "")
+		require.ErrorIs(t, err, ErrForbidden)
+		require.True(t, apierrors.IsForbidden(err))
+		require.Equal(t, 1, *calls)
+	})
+	t.Run("not-retryable", func(t *testing.T) {
+		client, calls := newFailingClient(1, notFound, labeled)
+		_, err := client.ListCandidates(context.TODO(), "")
+		require.Error(t, err)
+		require.Equal(t, "other", ErrorReason(err))
+		require.Equal(t, 1, *calls)
+	})
+	t.Run("context-done", func(t *testing.T) {
+		client, _ := newFailingClient(5, apierrors.NewInternalError(errors.New("etcd")), labeled)
+		client.Options.Retry.Backoff = time.Hour
+		client.Options.Retry.MaxBackoff = 0
+
+		ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+		defer cancel()
+		_, err := client.ListCandidates(ctx, "")
+		require.ErrorIs(t, err, ErrUnreachable)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+	t.Run("render", func(t *testing.T) {
+		client, _ := newFailingClient(5, apierrors.NewServiceUnavailable("maintenance"), labeled)
+		_, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.ErrorIs(t, err, ErrUnreachable)
+		sourceErr := &SourceError{}
+		require.ErrorAs(t, err, &sourceErr)
+	})
+}
+
+func TestPolicyDenied(t *testing.T) {
+	client := &Client{
+		Interface: testClient.NewSimpleClientset(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "invalid-pull-secret",
+				Namespace: "my-test-namespace",
+				Labels: map[string]string{
+					types.ReplicatorLabel: "true",
+				},
+			},
+			Type: corev1.SecretTypeDockerConfigJson,
+		}),
+		Policy: Policy{
+			InvalidSecrets: InvalidSecretsFail,
+		},
+	}
+
+	_, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+	require.ErrorIs(t, err, ErrPolicyDenied)
+	require.Equal(t, "policy-denied", ErrorReason(err))
+	// Only a source that could not be read may be served from the last good render
+	sourceErr := &SourceError{}
+	require.False(t, errors.As(err, &sourceErr))
+
+	// Invalid ArgoCD options fail the render regardless of the policy, from any source
+	typo := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "typo",
+			Namespace: "my-test-namespace",
+			Annotations: map[string]string{
+				types.ReplicatorAnnotationSyncOptions: "Prune=flase",
+			},
+		},
+	}
+	client.Policy = Policy{}
+	client.Sources = []SecretSource{staticSource{secrets: []corev1.Secret{typo}}}
+	_, err = client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+	require.ErrorIs(t, err, ErrPolicyDenied)
+	require.False(t, errors.As(err, &sourceErr))
+}
@@ -7,8 +7,11 @@ import (
 	"log/slog"
 	"slices"
 	"strings"
+	"time"
 
+	"github.com/plumber-cd/argocd-cmp-replicator/tracing"
 	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/printers"
@@ -19,58 +22,198 @@ func (c *Client) GetLabeledSecrets(ctx context.Context, namespace, alternativeLa
 	if alternativeLabelSelector != "" {
 		labelSelector = fmt.Sprintf("%s=%s,%s", types.ReplicatorLabelAlternative, "true", alternativeLabelSelector)
 	}
-	secrets, err := c.CoreV1().Secrets("").List(ctx, metav1.ListOptions{
-		LabelSelector: labelSelector,
-	})
+	secrets, err := c.listCandidates(ctx, labelSelector, namespace, &replicableFilter{c: c, namespace: namespace})
 	if err != nil {
 		return nil, err
 	}
 
-	slog.Debug("Listed labeled secrets", "count", len(secrets.Items))
+	return &corev1.SecretList{
+		Items: secrets,
+	}, nil
+}
+
+// replicableFilter keeps secrets that may be replicated to the namespace.
+// Decisions and stats are staged until the listing attempt is over, the secret is staged without its data.
+type replicableFilter struct {
+	c         *Client
+	namespace string
+	decisions []stagedDecision
+	skipped   []string
+}
 
-	filteredSecrets := &corev1.SecretList{
-		Items: []corev1.Secret{},
+type stagedDecision struct {
+	secret   corev1.Secret
+	decision Decision
+}
+
+func (f *replicableFilter) skip(reason string) {
+	f.skipped = append(f.skipped, reason)
+}
+
+func (f *replicableFilter) decided(secret corev1.Secret, decision Decision) {
+	if f.c.OnDecision == nil {
+		return
 	}
+	f.decisions = append(f.decisions, stagedDecision{
+		secret: corev1.Secret{
+			TypeMeta:   secret.TypeMeta,
+			ObjectMeta: secret.ObjectMeta,
+			Type:       secret.Type,
+		},
+		decision: describe(secret, decision),
+	})
+}
 
-	for _, secret := range secrets.Items {
+func (f *replicableFilter) Commit() {
+	for _, reason := range f.skipped {
+		f.c.Stats.skip(reason)
+	}
+	for _, staged := range f.decisions {
+		f.c.OnDecision(staged.secret, staged.decision)
+	}
+	f.Rollback()
+}
+
+func (f *replicableFilter) Rollback() {
+	f.decisions = nil
+	f.skipped = nil
+}
+
+// Keep checks if the secret may be replicated to the namespace
+func (f *replicableFilter) Keep(secret corev1.Secret) (bool, error) {
+	c, namespace := f.c, f.namespace
+	slog.Debug(
+		"Checking secret",
+		"name", secret.Name,
+		"namespace", secret.Namespace,
+		"thisNamespace", namespace,
+	)
+
+	matcher := ""
+	switch {
+	case matchSecretImplicitly(secret, namespace):
+		matcher = MatcherNamespace
+	case matchSecretByWildcard(secret, namespace):
+		matcher = MatcherWildcard
+	case matchSecretByList(secret, namespace):
+		matcher = MatcherList
+	}
+
+	if matcher == "" {
 		slog.Debug(
-			"Checking secret",
+			"Skipped secret",
 			"name", secret.Name,
 			"namespace", secret.Namespace,
 			"thisNamespace", namespace,
+			"allowedNamespacesStr", secret.Annotations[types.ReplicatorAnnotationAllowedNamespaces],
+		)
+		f.skip(SkipReasonNamespace)
+		f.decided(secret, Decision{Decision: DecisionSkipped, Reason: ReasonNotAllowed})
+		return false, nil
+	}
+
+	if len(indexLabels(secret)) > 0 && !indexAgrees(secret) {
+		slog.Warn(
+			"Secret index labels do not match allowed namespaces annotation, run sync",
+			"name", secret.Name,
+			"namespace", secret.Namespace,
 		)
+	}
+
+	// Retired secrets are left out on purpose, their replicas may be removed
+	if secret.Annotations[types.ReplicatorAnnotationRetire] == "true" {
+		slog.Info("Skipped retired secret", "name", secret.Name, "namespace", secret.Namespace)
+		f.skip(SkipReasonRetired)
+		f.decided(secret, Decision{Decision: DecisionSkipped, Matcher: matcher, Reason: ReasonRetired})
+		return false, nil
+	}
 
-		match := matchSecretImplicitly(secret, namespace) ||
-			matchSecretByWildcard(secret, namespace) ||
-			matchSecretByList(secret, namespace)
+	reason := ""
+	switch {
+	case !c.Policy.allowGrant(secret):
+		reason = ReasonGrantUnsigned
+	case !c.Policy.allowFieldManagers(secret):
+		reason = ReasonFieldManagers
+	case !activeGrant(secret):
+		reason = ReasonGrantWindow
+	case !c.Policy.allowStale(secret):
+		reason = ReasonStale
+	}
+	if reason != "" {
+		f.skip(SkipReasonPolicy)
+		f.decided(secret, Decision{Decision: DecisionSkipped, Matcher: matcher, Reason: reason})
+		return false, nil
+	}
+
+	// Leaving the secret out would have ArgoCD prune its replicas over a typo, so this always fails the render
+	if _, err := argoCDOptions(secret.Annotations); err != nil {
+		f.decided(secret, Decision{Decision: DecisionDenied, Matcher: matcher, Reason: ReasonArgoCDOptions})
+		return false, fmt.Errorf("%w: secret %s/%s has invalid ArgoCD options: %w", ErrPolicyDenied, secret.Namespace, secret.Name, err)
+	}
 
-		if !match {
-			slog.Debug(
-				"Skipped secret",
+	// Must be denied before it is recorded as replicated, the writer would fail the render anyway
+	if err := c.Policy.refuseExpiredCertificates(secret); err != nil {
+		f.decided(secret, Decision{Decision: DecisionDenied, Matcher: matcher, Reason: ReasonCertExpired})
+		return false, fmt.Errorf("%w: %w", ErrPolicyDenied, err)
+	}
+
+	if err := ValidateSecret(secret); err != nil {
+		switch c.Policy.InvalidSecrets {
+		case InvalidSecretsFail:
+			f.decided(secret, Decision{Decision: DecisionDenied, Matcher: matcher, Reason: ReasonInvalid})
+			return false, fmt.Errorf("%w: %w", ErrPolicyDenied, err)
+		case InvalidSecretsSkip:
+			slog.Warn(
+				"Skipped invalid secret",
+				"name", secret.Name,
+				"namespace", secret.Namespace,
+				"err", err,
+			)
+			f.skip(SkipReasonInvalid)
+			f.decided(secret, Decision{Decision: DecisionSkipped, Matcher: matcher, Reason: ReasonInvalid})
+			return false, nil
+		default:
+			// Replicas were always copied as they are, skipping them would have them pruned
+			slog.Warn(
+				"Replicating invalid secret",
 				"name", secret.Name,
 				"namespace", secret.Namespace,
-				"thisNamespace", namespace,
-				"allowedNamespacesStr", secret.Annotations[types.ReplicatorAnnotationAllowedNamespaces],
+				"err", err,
 			)
-			continue
 		}
-
-		filteredSecrets.Items = append(filteredSecrets.Items, secret)
 	}
 
-	return filteredSecrets, nil
+	f.decided(secret, Decision{Decision: DecisionReplicated, Matcher: matcher})
+	return true, nil
 }
 
-func (c *Client) WriteSecretListManifests(ctx context.Context, namespace string, secrets *corev1.SecretList, writer io.Writer) error {
+func (c *Client) WriteSecretListManifests(ctx context.Context, namespace string, secrets *corev1.SecretList, writer io.Writer) (err error) {
+	_, span := tracing.Tracer().Start(ctx, "write", trace.WithAttributes(tracing.AttributeSecrets.Int(len(secrets.Items))))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
 	printer := printers.YAMLPrinter{}
 	for _, secret := range secrets.Items {
-		newName := secret.Name + "-replicated-from-" + secret.Namespace
-		if secret.Annotations[types.ReplicatorAnnotationReplicatedName] != "" {
-			newName = secret.Annotations[types.ReplicatorAnnotationReplicatedName]
+		// These must be checked before annotations are modified below
+		certNotAfter, err := c.Policy.checkCertificates(secret)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrPolicyDenied, err)
+		}
+		stale, _, _ := c.Policy.isStale(secret)
+		argoCDOptions, err := argoCDOptions(secret.Annotations)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrPolicyDenied, err)
 		}
+		c.Stats.certificate(secret, certNotAfter)
+
+		newName := ReplicaName(secret)
 		newLabels := secret.Labels
 		if newLabels != nil {
 			delete(newLabels, types.ReplicatorLabel)
+			for label := range indexLabels(secret) {
+				delete(newLabels, label)
+			}
 		} else {
 			newLabels = map[string]string{}
 		}
@@ -80,9 +223,27 @@ func (c *Client) WriteSecretListManifests(ctx context.Context, namespace string,
 		}
 		delete(newAnnotations, types.ReplicatorAnnotationAllowedNamespaces)
 		delete(newAnnotations, types.ReplicatorAnnotationReplicatedName)
+		delete(newAnnotations, types.ReplicatorAnnotationGrantSignature)
+		delete(newAnnotations, types.ReplicatorAnnotationNotBefore)
+		delete(newAnnotations, types.ReplicatorAnnotationNotAfter)
+		delete(newAnnotations, types.ReplicatorAnnotationCertNotAfter)
+		delete(newAnnotations, types.ReplicatorAnnotationMaxAge)
+		delete(newAnnotations, types.ReplicatorAnnotationRotatedAt)
+		delete(newAnnotations, types.ReplicatorAnnotationStale)
+		delete(newAnnotations, types.ReplicatorAnnotationConsumers)
+		delete(newAnnotations, types.ReplicatorAnnotationSyncWave)
+		delete(newAnnotations, types.ReplicatorAnnotationSyncOptions)
+		delete(newAnnotations, types.ReplicatorAnnotationCompareOptions)
 		delete(newAnnotations, "kubectl.kubernetes.io/last-applied-configuration")
 		delete(newAnnotations, "argocd.argoproj.io/tracking-id")
 		newAnnotations[types.ReplicatorAnnotationFromNamespace] = secret.Namespace
+		if !certNotAfter.IsZero() {
+			newAnnotations[types.ReplicatorAnnotationCertNotAfter] = certNotAfter.UTC().Format(time.RFC3339)
+		}
+		if stale {
+			newAnnotations[types.ReplicatorAnnotationStale] = "true"
+		}
+		c.ArgoCDOptions.Override(argoCDOptions).annotate(newAnnotations)
 		newSecret := corev1.Secret{
 			TypeMeta: metav1.TypeMeta{
 				APIVersion: "v1",
@@ -104,6 +265,14 @@ func (c *Client) WriteSecretListManifests(ctx context.Context, namespace string,
 	return nil
 }
 
+// ReplicaName is the name the secret is replicated with
+func ReplicaName(secret corev1.Secret) string {
+	if secret.Annotations[types.ReplicatorAnnotationReplicatedName] != "" {
+		return secret.Annotations[types.ReplicatorAnnotationReplicatedName]
+	}
+	return secret.Name + "-replicated-from-" + secret.Namespace
+}
+
 func matchSecretImplicitly(secret corev1.Secret, namespace string) bool {
 	allowedNamespacesStr := secret.Annotations[types.ReplicatorAnnotationAllowedNamespaces]
 	match := (allowedNamespacesStr == "" || allowedNamespacesStr == "-") && secret.Namespace == namespace
@@ -136,7 +305,6 @@ func matchSecretByWildcard(secret corev1.Secret, namespace string) bool {
 
 func matchSecretByList(secret corev1.Secret, namespace string) bool {
 	allowedNamespacesStr := secret.Annotations[types.ReplicatorAnnotationAllowedNamespaces]
-	allowedNamespaces := []string{}
 
 	if strings.Contains(allowedNamespacesStr, ",") {
 		slog.Debug(
@@ -146,7 +314,6 @@ func matchSecretByList(secret corev1.Secret, namespace string) bool {
 			"thisNamespace", namespace,
 			"allowedNamespacesStr", allowedNamespacesStr,
 		)
-		allowedNamespaces = strings.Split(strings.TrimSpace(allowedNamespacesStr), ",")
 	} else {
 		slog.Debug(
 			"Secret has single allowed namespace",
@@ -155,10 +322,9 @@ func matchSecretByList(secret corev1.Secret, namespace string) bool {
 			"thisNamespace", namespace,
 			"allowedNamespacesStr", allowedNamespacesStr,
 		)
-		allowedNamespaces = append(allowedNamespaces, allowedNamespacesStr)
 	}
 
-	match := slices.Contains(allowedNamespaces, namespace)
+	match := slices.Contains(allowedNamespaceList(allowedNamespacesStr), namespace)
 	if match {
 		slog.Debug(
 			"Matched secret explicitly by allowed namespaces annotation",
@@ -170,3 +336,11 @@ func matchSecretByList(secret corev1.Secret, namespace string) bool {
 	}
 	return match
 }
+
+// allowedNamespaceList parses allowed namespaces annotation, the index must be derived from it the same way
+func allowedNamespaceList(allowedNamespacesStr string) []string {
+	if strings.Contains(allowedNamespacesStr, ",") {
+		return strings.Split(strings.TrimSpace(allowedNamespacesStr), ",")
+	}
+	return []string{allowedNamespacesStr}
+}
@@ -3,15 +3,20 @@ package k8s
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	_ "embed"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/plumber-cd/argocd-cmp-replicator/grants"
 	"github.com/plumber-cd/argocd-cmp-replicator/types"
 	"github.com/stretchr/testify/require"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
 
 	testClient "k8s.io/client-go/kubernetes/fake"
 )
@@ -19,6 +24,35 @@ import (
 //go:embed testdata/secrets.yaml
 var secretsYAML string
 
+// newLabeledSecret returns a candidate secret with the replicator label, annotations may be nil
+func newLabeledSecret(name, namespace string, annotations map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				types.ReplicatorLabel: "true",
+			},
+			Annotations: annotations,
+		},
+	}
+}
+
+// newBasicAuthSecret returns a candidate basic-auth secret, without data it is invalid
+func newBasicAuthSecret(name, namespace string, annotations map[string]string, data map[string][]byte) *corev1.Secret {
+	secret := newLabeledSecret(name, namespace, annotations)
+	secret.Type = corev1.SecretTypeBasicAuth
+	secret.Data = data
+	return secret
+}
+
+// newSecretWithUID returns a candidate secret as read from the API, only those get events and consumer status
+func newSecretWithUID(name, namespace string, uid k8stypes.UID, annotations map[string]string) *corev1.Secret {
+	secret := newLabeledSecret(name, namespace, annotations)
+	secret.UID = uid
+	return secret
+}
+
 func TestMatchSecretImplicitly(t *testing.T) {
 	t.Run("match-implicitly", func(t *testing.T) {
 		secret := corev1.Secret{
@@ -293,7 +327,7 @@ func TestGetLabeledSecrets(t *testing.T) {
 		)
 
 		client := Client{
-			_client,
+			Interface: _client,
 		}
 
 		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
@@ -480,7 +514,7 @@ func TestGetLabeledSecrets(t *testing.T) {
 		)
 
 		client := Client{
-			_client,
+			Interface: _client,
 		}
 
 		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "alternative-label=alternative-label-value")
@@ -520,7 +554,8 @@ func TestWriteSecretListManifests(t *testing.T) {
 					},
 					Annotations: map[string]string{
 						types.ReplicatorAnnotationAllowedNamespaces: "some-namespace",
-						"bar": "baz",
+						types.ReplicatorAnnotationRotatedAt:         "2024-03-01T00:00:00Z",
+						"bar":                                       "baz",
 					},
 				},
 				Data: map[string][]byte{
@@ -568,8 +603,197 @@ func TestWriteSecretListManifests(t *testing.T) {
 	}
 
 	buf := bytes.NewBufferString("")
-	client := Client{nil}
+	client := Client{}
 	client.WriteSecretListManifests(context.TODO(), "my-test-namespace", secrets, buf)
 
 	require.Equal(t, secretsYAML, buf.String())
 }
+
+func TestGetLabeledSecretsWithSignedGrants(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signed := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "labeled-secret-with-signed-grant",
+			Namespace: "some-other-namespace",
+			Labels: map[string]string{
+				types.ReplicatorLabel: "true",
+			},
+			Annotations: map[string]string{
+				types.ReplicatorAnnotationAllowedNamespaces: "*",
+			},
+		},
+	}
+	signed.Annotations[types.ReplicatorAnnotationGrantSignature] = grants.Sign(grants.FromSecret(*signed), private)
+
+	tampered := signed.DeepCopy()
+	tampered.Name = "labeled-secret-with-tampered-grant"
+
+	_client := testClient.NewSimpleClientset(
+		signed,
+		tampered,
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "labeled-secret-with-unsigned-grant",
+				Namespace: "some-other-namespace",
+				Labels: map[string]string{
+					types.ReplicatorLabel: "true",
+				},
+				Annotations: map[string]string{
+					types.ReplicatorAnnotationAllowedNamespaces: "*",
+				},
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "labeled-secret-with-unsigned-replicated-name",
+				Namespace: "my-test-namespace",
+				Labels: map[string]string{
+					types.ReplicatorLabel: "true",
+				},
+				Annotations: map[string]string{
+					types.ReplicatorAnnotationReplicatedName: "some-other-secret",
+				},
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "labeled-secret-implicit",
+				Namespace: "my-test-namespace",
+				Labels: map[string]string{
+					types.ReplicatorLabel: "true",
+				},
+			},
+		},
+	)
+
+	client := Client{
+		Interface: _client,
+		Policy: Policy{
+			TrustedGrantKeys: []ed25519.PublicKey{public},
+		},
+	}
+
+	secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+	require.NoError(t, err)
+
+	secretKeys := make([]string, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		secretKeys = append(secretKeys, fmt.Sprintf("%s/%s", secret.Namespace, secret.Name))
+	}
+	require.ElementsMatch(t, []string{
+		"some-other-namespace/labeled-secret-with-signed-grant",
+		"my-test-namespace/labeled-secret-implicit",
+	}, secretKeys)
+}
+
+func TestGetLabeledSecretsWithTrustedFieldManagers(t *testing.T) {
+	managedFields := func(manager string, fields string) metav1.ManagedFieldsEntry {
+		return metav1.ManagedFieldsEntry{
+			Manager:    manager,
+			Operation:  metav1.ManagedFieldsOperationApply,
+			APIVersion: "v1",
+			FieldsType: "FieldsV1",
+			FieldsV1:   &metav1.FieldsV1{Raw: []byte(fields)},
+		}
+	}
+	labelFields := `{"f:metadata":{"f:labels":{"f:plumber-cd.github.io/argocd-cmp-replicator":{}}}}`
+	annotationFields := `{"f:metadata":{"f:annotations":{"f:plumber-cd.github.io/argocd-cmp-replicator-allowed-namespaces":{}}}}`
+	dataFields := `{"f:data":{"f:key":{}}}`
+
+	newSecret := func(name string, managedFields ...metav1.ManagedFieldsEntry) *corev1.Secret {
+		secret := newLabeledSecret(name, "some-other-namespace", map[string]string{
+			types.ReplicatorAnnotationAllowedNamespaces: "*",
+		})
+		secret.ManagedFields = managedFields
+		return secret
+	}
+
+	_client := testClient.NewSimpleClientset(
+		newSecret(
+			"trusted",
+			managedFields("argocd-controller", labelFields),
+			managedFields("kubectl-platform", annotationFields),
+			managedFields("some-operator", dataFields),
+		),
+		newSecret(
+			"untrusted-annotation",
+			managedFields("argocd-controller", labelFields),
+			managedFields("kubectl-edit", annotationFields),
+		),
+		newSecret(
+			"untrusted-label",
+			managedFields("kubectl-label", labelFields),
+			managedFields("argocd-controller", annotationFields),
+		),
+		newSecret(
+			"no-managed-fields",
+		),
+		newSecret(
+			"broken-managed-fields",
+			managedFields("argocd-controller", `not json`),
+		),
+	)
+
+	client := Client{
+		Interface: _client,
+		Policy: Policy{
+			TrustedFieldManagers: []string{"argocd-controller", "kubectl-platform"},
+		},
+	}
+
+	secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+	require.NoError(t, err)
+
+	require.Len(t, secrets.Items, 1)
+	require.Equal(t, "trusted", secrets.Items[0].Name)
+}
+
+func TestGetLabeledSecretsWithGrantWindows(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	}
+	t.Cleanup(func() {
+		now = time.Now
+	})
+
+	newSecret := func(name, notBefore, notAfter string) *corev1.Secret {
+		secret := newLabeledSecret(name, "some-other-namespace", map[string]string{
+			types.ReplicatorAnnotationAllowedNamespaces: "my-test-namespace",
+		})
+		if notBefore != "" {
+			secret.Annotations[types.ReplicatorAnnotationNotBefore] = notBefore
+		}
+		if notAfter != "" {
+			secret.Annotations[types.ReplicatorAnnotationNotAfter] = notAfter
+		}
+		return secret
+	}
+
+	_client := testClient.NewSimpleClientset(
+		newSecret("no-window", "", ""),
+		newSecret("active", "2024-02-15T00:00:00Z", "2024-03-15T00:00:00Z"),
+		newSecret("active-open-ended", "2024-02-15T00:00:00+02:00", ""),
+		newSecret("not-active-yet", "2024-03-02T00:00:00Z", ""),
+		newSecret("expired", "", "2024-03-01T12:00:00Z"),
+		newSecret("invalid", "", "next tuesday"),
+	)
+
+	client := Client{
+		Interface: _client,
+	}
+
+	secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+	require.NoError(t, err)
+
+	secretKeys := make([]string, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		secretKeys = append(secretKeys, secret.Name)
+	}
+	require.ElementsMatch(t, []string{
+		"no-window",
+		"active",
+		"active-open-ended",
+	}, secretKeys)
+}
@@ -0,0 +1,185 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/tracing"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SecretReference identifies a secret within a source
+type SecretReference struct {
+	Namespace string
+	Name      string
+}
+
+// SecretSource is where replicable secrets come from.
+// Sources only find candidates - matchers, policies and writers are applied the same way regardless of the source.
+type SecretSource interface {
+	// ListCandidates returns all secrets matching the label selector, before allowed namespaces are checked
+	ListCandidates(ctx context.Context, labelSelector string) ([]corev1.Secret, error)
+	// Get returns a single secret by reference
+	Get(ctx context.Context, ref SecretReference) (*corev1.Secret, error)
+	// Describe returns a human readable name of the source for logs
+	Describe() string
+}
+
+// SourceError is returned when a source could not be read, as opposed to a secret refused by the policy.
+// Sources wrap only errors of reading from them, errors of keep are returned as they are.
+type SourceError struct {
+	Source string
+	Err    error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("failed to read secrets from %s: %v", e.Source, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// ListCandidates implements SecretSource for the cluster the client is connected to
+func (c *Client) ListCandidates(ctx context.Context, labelSelector string) ([]corev1.Secret, error) {
+	return c.ListFilteredCandidates(ctx, labelSelector, KeepFunc(func(corev1.Secret) (bool, error) {
+		return true, nil
+	}))
+}
+
+// Get implements SecretSource for the cluster the client is connected to
+func (c *Client) Get(ctx context.Context, ref SecretReference) (*corev1.Secret, error) {
+	var secret *corev1.Secret
+	err := c.Options.Retry.do(ctx, "get secret", func() (err error) {
+		secret, err = c.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		return err
+	})
+	return secret, err
+}
+
+// Describe implements SecretSource for the cluster the client is connected to
+func (c *Client) Describe() string {
+	if c.ClusterName != "" {
+		return "cluster " + c.ClusterName
+	}
+	return "kubernetes"
+}
+
+// sources returns configured sources, the client itself is the default
+func (c *Client) sources() []SecretSource {
+	if len(c.Sources) == 0 {
+		return []SecretSource{c}
+	}
+	return c.Sources
+}
+
+// Filter decides which candidates are kept as they are listed.
+// Side effects of its decisions, like audit records and stats, wait for Commit,
+// so a listing that starts over does not record them twice.
+type Filter interface {
+	// Keep returns true for candidates to keep
+	Keep(secret corev1.Secret) (bool, error)
+	// Commit applies side effects of Keep since the last Commit or Rollback, sources call it once a listing attempt is over
+	Commit()
+	// Rollback drops side effects of Keep since the last Commit, sources call it when listing starts over
+	Rollback()
+}
+
+// KeepFunc is a Filter without side effects
+type KeepFunc func(corev1.Secret) (bool, error)
+
+func (f KeepFunc) Keep(secret corev1.Secret) (bool, error) {
+	return f(secret)
+}
+
+func (f KeepFunc) Commit() {}
+
+func (f KeepFunc) Rollback() {}
+
+// FilteringSource is implemented by sources that can filter candidates as they arrive,
+// so they never have to hold all of them in memory at once
+type FilteringSource interface {
+	// ListFilteredCandidates is the same as ListCandidates, but only returns secrets the filter kept
+	ListFilteredCandidates(ctx context.Context, labelSelector string, filter Filter) ([]corev1.Secret, error)
+}
+
+// IndexedSource is implemented by sources that can narrow down the List to secrets that may be replicated to the namespace
+type IndexedSource interface {
+	// ListIndexedCandidates is the same as ListFilteredCandidates, but may skip secrets that can't be replicated to the namespace
+	ListIndexedCandidates(ctx context.Context, labelSelector, namespace string, filter Filter) ([]corev1.Secret, error)
+}
+
+// listCandidates returns candidates for the namespace from all sources that the filter kept.
+// The same secret found in more than one source is only returned from the first one, ArgoCD rejects duplicate resources.
+func (c *Client) listCandidates(ctx context.Context, labelSelector, namespace string, filter Filter) ([]corev1.Secret, error) {
+	candidates := []corev1.Secret{}
+	seen := map[SecretReference]string{}
+	for _, source := range c.sources() {
+		ctx, span := tracing.Tracer().Start(ctx, "list", trace.WithAttributes(tracing.AttributeSource.String(source.Describe())))
+		secrets, err := listFilteredCandidates(ctx, source, labelSelector, namespace, filter)
+		if err != nil {
+			slog.Error("Failed to list secrets", "source", source.Describe(), "err", err)
+			tracing.End(span, err)
+			return nil, err
+		}
+		slog.Debug("Listed labeled secrets", "source", source.Describe(), "kept", len(secrets))
+		span.SetAttributes(tracing.AttributeKept.Int(len(secrets)))
+		tracing.End(span, nil)
+		for _, secret := range secrets {
+			ref := SecretReference{Namespace: secret.Namespace, Name: secret.Name}
+			if first, ok := seen[ref]; ok {
+				slog.Warn(
+					"Skipped secret found in more than one source",
+					"name", secret.Name,
+					"namespace", secret.Namespace,
+					"source", source.Describe(),
+					"firstSource", first,
+				)
+				continue
+			}
+			seen[ref] = source.Describe()
+			candidates = append(candidates, secret)
+		}
+	}
+	return candidates, nil
+}
+
+func listFilteredCandidates(ctx context.Context, source SecretSource, labelSelector, namespace string, filter Filter) ([]corev1.Secret, error) {
+	if indexed, ok := source.(IndexedSource); ok {
+		return indexed.ListIndexedCandidates(ctx, labelSelector, namespace, filter)
+	}
+	if filtering, ok := source.(FilteringSource); ok {
+		return filtering.ListFilteredCandidates(ctx, labelSelector, filter)
+	}
+
+	secrets, err := source.ListCandidates(ctx, labelSelector)
+	if err != nil {
+		return nil, &SourceError{Source: source.Describe(), Err: err}
+	}
+	defer filter.Commit()
+	return match(ctx, secrets, filter)
+}
+
+// match returns secrets the filter kept
+func match(ctx context.Context, secrets []corev1.Secret, filter Filter) (kept []corev1.Secret, err error) {
+	_, span := tracing.Tracer().Start(ctx, "match", trace.WithAttributes(tracing.AttributeCandidates.Int(len(secrets))))
+	defer func() {
+		span.SetAttributes(tracing.AttributeKept.Int(len(kept)))
+		tracing.End(span, err)
+	}()
+
+	kept = []corev1.Secret{}
+	for _, secret := range secrets {
+		ok, err := filter.Keep(secret)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			kept = append(kept, secret)
+		}
+	}
+	return kept, nil
+}
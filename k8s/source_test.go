@@ -0,0 +1,135 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+type staticSource struct {
+	secrets []corev1.Secret
+	err     error
+}
+
+func (s staticSource) ListCandidates(ctx context.Context, labelSelector string) ([]corev1.Secret, error) {
+	return s.secrets, s.err
+}
+
+func (s staticSource) Get(ctx context.Context, ref SecretReference) (*corev1.Secret, error) {
+	for _, secret := range s.secrets {
+		if secret.Namespace == ref.Namespace && secret.Name == ref.Name {
+			return &secret, nil
+		}
+	}
+	return nil, fmt.Errorf("not found")
+}
+
+func (s staticSource) Describe() string {
+	return "static"
+}
+
+func TestGetLabeledSecretsFromSources(t *testing.T) {
+	client := &Client{
+		Interface: testClient.NewSimpleClientset(
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "from-cluster",
+					Namespace: "my-test-namespace",
+					Labels: map[string]string{
+						types.ReplicatorLabel: "true",
+					},
+				},
+			},
+		),
+	}
+
+	static := staticSource{
+		secrets: []corev1.Secret{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "from-static",
+					Namespace: "some-other-namespace",
+					Annotations: map[string]string{
+						types.ReplicatorAnnotationAllowedNamespaces: "*",
+					},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "from-static-for-another-namespace",
+					Namespace: "some-other-namespace",
+				},
+			},
+		},
+	}
+
+	t.Run("default", func(t *testing.T) {
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		require.Len(t, secrets.Items, 1)
+		require.Equal(t, "from-cluster", secrets.Items[0].Name)
+	})
+	t.Run("combined", func(t *testing.T) {
+		client.Sources = []SecretSource{client, static}
+		t.Cleanup(func() {
+			client.Sources = nil
+		})
+
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+
+		secretKeys := []string{}
+		for _, secret := range secrets.Items {
+			secretKeys = append(secretKeys, secret.Name)
+		}
+		require.Equal(t, []string{"from-cluster", "from-static"}, secretKeys)
+	})
+	t.Run("duplicate", func(t *testing.T) {
+		duplicate := staticSource{
+			secrets: []corev1.Secret{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "from-cluster",
+						Namespace: "my-test-namespace",
+						Annotations: map[string]string{
+							"source": "static",
+						},
+					},
+				},
+			},
+		}
+		client.Sources = []SecretSource{client, duplicate, static}
+		t.Cleanup(func() {
+			client.Sources = nil
+		})
+
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		require.Len(t, secrets.Items, 2)
+		require.Equal(t, "from-cluster", secrets.Items[0].Name)
+		require.NotContains(t, secrets.Items[0].Annotations, "source")
+		require.Equal(t, "from-static", secrets.Items[1].Name)
+	})
+	t.Run("error", func(t *testing.T) {
+		client.Sources = []SecretSource{client, staticSource{err: fmt.Errorf("boom")}}
+		t.Cleanup(func() {
+			client.Sources = nil
+		})
+
+		_, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.Error(t, err)
+	})
+	t.Run("get", func(t *testing.T) {
+		secret, err := client.Get(context.TODO(), SecretReference{Namespace: "my-test-namespace", Name: "from-cluster"})
+		require.NoError(t, err)
+		require.Equal(t, "from-cluster", secret.Name)
+	})
+}
@@ -0,0 +1,117 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	StaleSecretsMark    = "mark"
+	StaleSecretsExclude = "exclude"
+)
+
+// lastRotated is when the secret content was last changed.
+// Rotation annotation takes precedence, otherwise it is the latest managed fields entry that owns data.
+// Zero time means it is unknown.
+func lastRotated(secret corev1.Secret) (time.Time, error) {
+	if v := secret.Annotations[types.ReplicatorAnnotationRotatedAt]; v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s: %w", types.ReplicatorAnnotationRotatedAt, err)
+		}
+		return t, nil
+	}
+
+	last := time.Time{}
+	for _, entry := range secret.ManagedFields {
+		if entry.FieldsV1 == nil || entry.Time == nil {
+			continue
+		}
+
+		fields := struct {
+			Data       json.RawMessage `json:"f:data"`
+			StringData json.RawMessage `json:"f:stringData"`
+		}{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			return time.Time{}, err
+		}
+		if fields.Data == nil && fields.StringData == nil {
+			continue
+		}
+
+		if entry.Time.After(last) {
+			last = entry.Time.Time
+		}
+	}
+	return last, nil
+}
+
+// maxAge is the stricter of global and per-secret max age, zero means there is no limit.
+func (p Policy) maxAge(secret corev1.Secret) (time.Duration, error) {
+	maxAge := p.MaxSecretAge
+	if v := secret.Annotations[types.ReplicatorAnnotationMaxAge]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", types.ReplicatorAnnotationMaxAge, err)
+		}
+		if maxAge == 0 || d < maxAge {
+			maxAge = d
+		}
+	}
+	return maxAge, nil
+}
+
+// isStale returns an error if it can't be determined, in which case the secret is treated as not stale.
+func (p Policy) isStale(secret corev1.Secret) (bool, time.Time, error) {
+	maxAge, err := p.maxAge(secret)
+	if err != nil || maxAge == 0 {
+		return false, time.Time{}, err
+	}
+
+	rotated, err := lastRotated(secret)
+	if err != nil || rotated.IsZero() {
+		return false, rotated, err
+	}
+
+	return now().Sub(rotated) > maxAge, rotated, nil
+}
+
+// allowStale logs stale secrets and decides whether they can be replicated.
+func (p Policy) allowStale(secret corev1.Secret) bool {
+	stale, rotated, err := p.isStale(secret)
+	if err != nil {
+		slog.Warn(
+			"Failed to check if secret is stale",
+			"name", secret.Name,
+			"namespace", secret.Namespace,
+			"err", err,
+		)
+		return true
+	}
+	if !stale {
+		return true
+	}
+
+	if p.StaleSecrets == StaleSecretsExclude {
+		slog.Warn(
+			"Skipped stale secret",
+			"name", secret.Name,
+			"namespace", secret.Namespace,
+			"lastRotated", rotated,
+		)
+		return false
+	}
+
+	slog.Warn(
+		"Secret is stale",
+		"name", secret.Name,
+		"namespace", secret.Namespace,
+		"lastRotated", rotated,
+	)
+	return true
+}
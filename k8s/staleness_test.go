@@ -0,0 +1,164 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func newStalenessTestSecret(name string, annotations map[string]string, dataChanged time.Time) *corev1.Secret {
+	labelsChanged := metav1.NewTime(time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC))
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "my-test-namespace",
+			Labels: map[string]string{
+				types.ReplicatorLabel: "true",
+			},
+			Annotations: annotations,
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{
+					Manager:  "kubectl-label",
+					Time:     &labelsChanged,
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:plumber-cd.github.io/argocd-cmp-replicator":{}}}}`)},
+				},
+			},
+		},
+	}
+	if !dataChanged.IsZero() {
+		t := metav1.NewTime(dataChanged)
+		secret.ManagedFields = append(secret.ManagedFields, metav1.ManagedFieldsEntry{
+			Manager:  "some-operator",
+			Time:     &t,
+			FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:data":{"f:key":{}}}`)},
+		})
+	}
+	return secret
+}
+
+func TestLastRotated(t *testing.T) {
+	t.Run("annotation", func(t *testing.T) {
+		secret := newStalenessTestSecret("some-secret", map[string]string{
+			types.ReplicatorAnnotationRotatedAt: "2024-02-01T00:00:00Z",
+		}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+		rotated, err := lastRotated(*secret)
+		require.NoError(t, err)
+		require.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), rotated)
+	})
+	t.Run("managed-fields", func(t *testing.T) {
+		secret := newStalenessTestSecret("some-secret", nil, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+		rotated, err := lastRotated(*secret)
+		require.NoError(t, err)
+		require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), rotated.UTC())
+	})
+	t.Run("unknown", func(t *testing.T) {
+		secret := newStalenessTestSecret("some-secret", nil, time.Time{})
+
+		rotated, err := lastRotated(*secret)
+		require.NoError(t, err)
+		require.True(t, rotated.IsZero())
+	})
+	t.Run("invalid-annotation", func(t *testing.T) {
+		secret := newStalenessTestSecret("some-secret", map[string]string{
+			types.ReplicatorAnnotationRotatedAt: "yesterday",
+		}, time.Time{})
+
+		_, err := lastRotated(*secret)
+		require.Error(t, err)
+	})
+}
+
+func TestStaleSecrets(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	}
+	t.Cleanup(func() {
+		now = time.Now
+	})
+
+	newClient := func(staleSecrets string) Client {
+		return Client{
+			Interface: testClient.NewSimpleClientset(
+				newStalenessTestSecret("fresh", nil, time.Date(2024, 2, 20, 0, 0, 0, 0, time.UTC)),
+				newStalenessTestSecret("stale", nil, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+				newStalenessTestSecret("stale-by-annotation", map[string]string{
+					types.ReplicatorAnnotationMaxAge: "168h",
+				}, time.Date(2024, 2, 20, 0, 0, 0, 0, time.UTC)),
+				newStalenessTestSecret("annotation-can-not-relax-policy", map[string]string{
+					types.ReplicatorAnnotationMaxAge: "8760h",
+				}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+				newStalenessTestSecret("unknown", nil, time.Time{}),
+			),
+			Policy: Policy{
+				MaxSecretAge: 30 * 24 * time.Hour,
+				StaleSecrets: staleSecrets,
+			},
+		}
+	}
+
+	t.Run("exclude", func(t *testing.T) {
+		client := newClient(StaleSecretsExclude)
+
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+
+		secretKeys := []string{}
+		for _, secret := range secrets.Items {
+			secretKeys = append(secretKeys, secret.Name)
+		}
+		require.ElementsMatch(t, []string{"fresh", "unknown"}, secretKeys)
+	})
+	t.Run("mark", func(t *testing.T) {
+		client := newClient(StaleSecretsMark)
+
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		require.Len(t, secrets.Items, 5)
+
+		buf := bytes.NewBufferString("")
+		require.NoError(t, client.WriteSecretListManifests(context.TODO(), "my-test-namespace", secrets, buf))
+
+		stale := []string{}
+		for _, doc := range bytes.Split(buf.Bytes(), []byte("\n---\n")) {
+			secret := corev1.Secret{}
+			require.NoError(t, yaml.Unmarshal(doc, &secret))
+			if secret.Annotations[types.ReplicatorAnnotationStale] == "true" {
+				stale = append(stale, secret.Name)
+			}
+		}
+		require.ElementsMatch(t, []string{
+			"stale-replicated-from-my-test-namespace",
+			"stale-by-annotation-replicated-from-my-test-namespace",
+			"annotation-can-not-relax-policy-replicated-from-my-test-namespace",
+		}, stale)
+	})
+	t.Run("lint", func(t *testing.T) {
+		client := newClient(StaleSecretsMark)
+
+		findings, err := client.Lint(context.TODO(), LintOptions{})
+		require.NoError(t, err)
+
+		reasons := map[string]string{}
+		for _, finding := range findings {
+			reasons[finding.Name] = finding.Reason
+		}
+		require.Equal(t, map[string]string{
+			"stale":                           LintReasonStale,
+			"stale-by-annotation":             LintReasonStale,
+			"annotation-can-not-relax-policy": LintReasonStale,
+			"unknown":                         LintReasonUnknownAge,
+		}, reasons)
+	})
+}
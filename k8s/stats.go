@@ -0,0 +1,51 @@
+package k8s
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	SkipReasonNamespace = "namespace"
+	SkipReasonPolicy    = "policy"
+	SkipReasonInvalid   = "invalid"
+	SkipReasonRetired   = "retired"
+)
+
+// Stats collects what happened during a render, for metrics.
+// It only holds counts and names, never secret data.
+type Stats struct {
+	// Skipped is how many candidates were not replicated by reason
+	Skipped map[string]int
+	// Certificates are expiry times of replicated certificates
+	Certificates []CertificateStat
+}
+
+// CertificateStat is the earliest expiry of certificates in a replicated source secret
+type CertificateStat struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+func (s *Stats) skip(reason string) {
+	if s == nil {
+		return
+	}
+	if s.Skipped == nil {
+		s.Skipped = map[string]int{}
+	}
+	s.Skipped[reason]++
+}
+
+func (s *Stats) certificate(secret corev1.Secret, notAfter time.Time) {
+	if s == nil || notAfter.IsZero() {
+		return
+	}
+	s.Certificates = append(s.Certificates, CertificateStat{
+		Namespace: secret.Namespace,
+		Name:      secret.Name,
+		NotAfter:  notAfter,
+	})
+}
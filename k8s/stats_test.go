@@ -0,0 +1,51 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStats(t *testing.T) {
+	valid := map[string][]byte{
+		corev1.BasicAuthPasswordKey: []byte("foo"),
+	}
+
+	stats := &Stats{}
+	client := Client{
+		Interface: testClient.NewSimpleClientset(
+			newBasicAuthSecret("valid", "my-test-namespace", nil, valid),
+			newBasicAuthSecret("invalid", "my-test-namespace", nil, nil),
+			newBasicAuthSecret("other-namespace", "some-other-namespace", nil, valid),
+			newBasicAuthSecret("expired-grant", "some-other-namespace", map[string]string{
+				types.ReplicatorAnnotationAllowedNamespaces: "*",
+				types.ReplicatorAnnotationNotAfter:          time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+			}, valid),
+		),
+		Policy: Policy{
+			InvalidSecrets: InvalidSecretsSkip,
+		},
+		Stats: stats,
+	}
+
+	secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+	require.NoError(t, err)
+	require.Len(t, secrets.Items, 1)
+	require.Equal(t, map[string]int{
+		SkipReasonNamespace: 1,
+		SkipReasonPolicy:    1,
+		SkipReasonInvalid:   1,
+	}, stats.Skipped)
+
+	// Without stats nothing is collected, and nothing breaks
+	client.Stats = nil
+	_, err = client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+	require.NoError(t, err)
+}
@@ -0,0 +1,83 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/tracing"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	client := Client{
+		Interface: testClient.NewSimpleClientset(
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "labeled-secret",
+					Namespace: "my-test-namespace",
+					Labels: map[string]string{
+						types.ReplicatorLabel: "true",
+					},
+				},
+				Data: map[string][]byte{
+					"password": []byte("super-secret-value"),
+				},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "other-secret",
+					Namespace: "some-other-namespace",
+					Labels: map[string]string{
+						types.ReplicatorLabel: "true",
+					},
+				},
+			},
+		),
+	}
+
+	secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+	require.NoError(t, err)
+	require.NoError(t, client.WriteSecretListManifests(context.TODO(), "my-test-namespace", secrets, &bytes.Buffer{}))
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+		for _, attribute := range span.Attributes() {
+			require.NotContains(t, attribute.Value.Emit(), "super-secret-value")
+		}
+	}
+	require.Contains(t, spans, "list")
+	require.Contains(t, spans, "list page")
+	require.Contains(t, spans, "match")
+	require.Contains(t, spans, "write")
+
+	attributes := func(span sdktrace.ReadOnlySpan) map[string]interface{} {
+		result := map[string]interface{}{}
+		for _, attribute := range span.Attributes() {
+			result[string(attribute.Key)] = attribute.Value.AsInterface()
+		}
+		return result
+	}
+	require.Equal(t, "kubernetes", attributes(spans["list"])[string(tracing.AttributeSource)])
+	require.Equal(t, int64(1), attributes(spans["list"])[string(tracing.AttributeKept)])
+	require.Equal(t, int64(2), attributes(spans["match"])[string(tracing.AttributeCandidates)])
+	require.Equal(t, int64(1), attributes(spans["write"])[string(tracing.AttributeSecrets)])
+	require.Equal(t, spans["list"].SpanContext().SpanID(), spans["list page"].Parent().SpanID())
+}
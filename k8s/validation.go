@@ -0,0 +1,81 @@
+package k8s
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	InvalidSecretsFail = "fail"
+	InvalidSecretsSkip = "skip"
+	// InvalidSecretsWarn replicates invalid secrets as they are, with a warning
+	InvalidSecretsWarn = "warn"
+)
+
+// ValidateSecret checks that data of well-known secret types is usable, other types are not checked.
+func ValidateSecret(secret corev1.Secret) error {
+	var err error
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		err = validateDockerConfigJson(secret)
+	case corev1.SecretTypeTLS:
+		err = validateTLS(secret)
+	case corev1.SecretTypeBasicAuth:
+		err = validateBasicAuth(secret)
+	case corev1.SecretTypeSSHAuth:
+		err = requireKeys(secret, corev1.SSHAuthPrivateKey)
+	}
+	if err != nil {
+		return fmt.Errorf("secret %s/%s of type %s is invalid: %w", secret.Namespace, secret.Name, secret.Type, err)
+	}
+	return nil
+}
+
+func requireKeys(secret corev1.Secret, keys ...string) error {
+	for _, key := range keys {
+		if len(secret.Data[key]) == 0 {
+			return fmt.Errorf("missing %s", key)
+		}
+	}
+	return nil
+}
+
+func validateDockerConfigJson(secret corev1.Secret) error {
+	if err := requireKeys(secret, corev1.DockerConfigJsonKey); err != nil {
+		return err
+	}
+
+	config := struct {
+		Auths map[string]json.RawMessage `json:"auths"`
+	}{}
+	if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+		return fmt.Errorf("%s is not valid JSON: %w", corev1.DockerConfigJsonKey, err)
+	}
+	if config.Auths == nil {
+		return fmt.Errorf("%s has no auths", corev1.DockerConfigJsonKey)
+	}
+	return nil
+}
+
+func validateTLS(secret corev1.Secret) error {
+	if err := requireKeys(secret, corev1.TLSCertKey, corev1.TLSPrivateKeyKey); err != nil {
+		return err
+	}
+
+	// Fails if either can't be parsed or the private key does not match the public key in the leaf certificate
+	if _, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]); err != nil {
+		return fmt.Errorf("%s/%s: %w", corev1.TLSCertKey, corev1.TLSPrivateKeyKey, err)
+	}
+	return nil
+}
+
+// validateBasicAuth follows API server rules - at least one of the keys must be present
+func validateBasicAuth(secret corev1.Secret) error {
+	if len(secret.Data[corev1.BasicAuthUsernameKey]) == 0 && len(secret.Data[corev1.BasicAuthPasswordKey]) == 0 {
+		return fmt.Errorf("missing %s or %s", corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package k8s
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestCertificate returns PEM encoded self-signed certificate and its private key
+func newTestCertificate(t *testing.T, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestValidateSecret(t *testing.T) {
+	cert, key := newTestCertificate(t, time.Now().Add(24*time.Hour))
+	_, otherKey := newTestCertificate(t, time.Now().Add(24*time.Hour))
+
+	tests := []struct {
+		name       string
+		secretType corev1.SecretType
+		data       map[string]string
+		valid      bool
+	}{
+		{"opaque", corev1.SecretTypeOpaque, map[string]string{}, true},
+		{"dockerconfigjson", corev1.SecretTypeDockerConfigJson, map[string]string{
+			corev1.DockerConfigJsonKey: `{"auths":{"ghcr.io":{"auth":"Zm9vOmJhcg=="}}}`,
+		}, true},
+		{"dockerconfigjson-missing", corev1.SecretTypeDockerConfigJson, map[string]string{}, false},
+		{"dockerconfigjson-not-json", corev1.SecretTypeDockerConfigJson, map[string]string{
+			corev1.DockerConfigJsonKey: `auths: {}`,
+		}, false},
+		{"dockerconfigjson-no-auths", corev1.SecretTypeDockerConfigJson, map[string]string{
+			corev1.DockerConfigJsonKey: `{"ghcr.io":{"auth":"Zm9vOmJhcg=="}}`,
+		}, false},
+		{"tls", corev1.SecretTypeTLS, map[string]string{
+			corev1.TLSCertKey:       string(cert),
+			corev1.TLSPrivateKeyKey: string(key),
+		}, true},
+		{"tls-missing-key", corev1.SecretTypeTLS, map[string]string{
+			corev1.TLSCertKey: string(cert),
+		}, false},
+		{"tls-mismatched-key", corev1.SecretTypeTLS, map[string]string{
+			corev1.TLSCertKey:       string(cert),
+			corev1.TLSPrivateKeyKey: string(otherKey),
+		}, false},
+		{"tls-garbage", corev1.SecretTypeTLS, map[string]string{
+			corev1.TLSCertKey:       "foo",
+			corev1.TLSPrivateKeyKey: "bar",
+		}, false},
+		{"basic-auth", corev1.SecretTypeBasicAuth, map[string]string{
+			corev1.BasicAuthUsernameKey: "foo",
+		}, true},
+		{"basic-auth-empty", corev1.SecretTypeBasicAuth, map[string]string{}, false},
+		{"ssh-auth", corev1.SecretTypeSSHAuth, map[string]string{
+			corev1.SSHAuthPrivateKey: "foo",
+		}, true},
+		{"ssh-auth-empty", corev1.SecretTypeSSHAuth, map[string]string{
+			corev1.SSHAuthPrivateKey: "",
+		}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secret := corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "some-secret",
+					Namespace: "some-namespace",
+				},
+				Type: test.secretType,
+				Data: map[string][]byte{},
+			}
+			for k, v := range test.data {
+				secret.Data[k] = []byte(v)
+			}
+
+			err := ValidateSecret(secret)
+			if test.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestGetLabeledSecretsWithInvalidSecrets(t *testing.T) {
+	newClient := func(invalidSecrets string) Client {
+		return Client{
+			Interface: testClient.NewSimpleClientset(
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "valid",
+						Namespace: "my-test-namespace",
+						Labels: map[string]string{
+							types.ReplicatorLabel: "true",
+						},
+					},
+					Type: corev1.SecretTypeBasicAuth,
+					Data: map[string][]byte{
+						corev1.BasicAuthPasswordKey: []byte("foo"),
+					},
+				},
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "invalid",
+						Namespace: "my-test-namespace",
+						Labels: map[string]string{
+							types.ReplicatorLabel: "true",
+						},
+					},
+					Type: corev1.SecretTypeBasicAuth,
+				},
+			),
+			Policy: Policy{
+				InvalidSecrets: invalidSecrets,
+			},
+		}
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		client := newClient(InvalidSecretsSkip)
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		require.Len(t, secrets.Items, 1)
+		require.Equal(t, "valid", secrets.Items[0].Name)
+	})
+	t.Run("fail", func(t *testing.T) {
+		client := newClient(InvalidSecretsFail)
+		_, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.ErrorContains(t, err, "my-test-namespace/invalid")
+	})
+	t.Run("warn", func(t *testing.T) {
+		for _, invalidSecrets := range []string{InvalidSecretsWarn, ""} {
+			client := newClient(invalidSecrets)
+			secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+			require.NoError(t, err)
+			require.Len(t, secrets.Items, 2)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package k8s
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// now is replaced in tests
+var now = time.Now
+
+// grantWindow returns not-before and not-after annotations, zero time means the bound is not set.
+func grantWindow(secret corev1.Secret) (time.Time, time.Time, error) {
+	notBefore, notAfter := time.Time{}, time.Time{}
+	if v := secret.Annotations[types.ReplicatorAnnotationNotBefore]; v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return notBefore, notAfter, fmt.Errorf("invalid %s: %w", types.ReplicatorAnnotationNotBefore, err)
+		}
+		notBefore = t
+	}
+	if v := secret.Annotations[types.ReplicatorAnnotationNotAfter]; v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return notBefore, notAfter, fmt.Errorf("invalid %s: %w", types.ReplicatorAnnotationNotAfter, err)
+		}
+		notAfter = t
+	}
+	return notBefore, notAfter, nil
+}
+
+func activeGrant(secret corev1.Secret) bool {
+	notBefore, notAfter, err := grantWindow(secret)
+	if err != nil {
+		slog.Warn(
+			"Skipped secret with invalid grant window",
+			"name", secret.Name,
+			"namespace", secret.Namespace,
+			"err", err,
+		)
+		return false
+	}
+
+	t := now()
+	if !notBefore.IsZero() && t.Before(notBefore) {
+		slog.Info(
+			"Skipped secret with grant not active yet",
+			"name", secret.Name,
+			"namespace", secret.Namespace,
+			"notBefore", notBefore,
+		)
+		return false
+	}
+	if !notAfter.IsZero() && !t.Before(notAfter) {
+		slog.Warn(
+			"Skipped secret with expired grant",
+			"name", secret.Name,
+			"namespace", secret.Namespace,
+			"notAfter", notAfter,
+		)
+		return false
+	}
+
+	return true
+}
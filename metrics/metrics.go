@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "argocd_cmp_replicator"
+
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+	// ResultStale is a failed render that was answered with the last good render
+	ResultStale = "stale"
+)
+
+// Report is what a render sends to the long-running server to be exposed as metrics.
+// Renders are short-lived processes, so they can't be scraped themselves.
+type Report struct {
+	App             string  `json:"app"`
+	Project         string  `json:"project"`
+	Destination     string  `json:"destination"`
+	Result          string  `json:"result"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Emitted         int     `json:"emitted"`
+	// Skipped is how many candidates were not replicated by k8s.SkipReason*
+	Skipped map[string]int `json:"skipped,omitempty"`
+	// ErrorReason is k8s.ErrorReason of the error that failed the render, if any
+	ErrorReason string `json:"errorReason,omitempty"`
+	// CacheHits and CacheMisses count requests answered by the secrets cache and the ones that fell back to the API
+	CacheHits    int                   `json:"cacheHits"`
+	CacheMisses  int                   `json:"cacheMisses"`
+	Certificates []k8s.CertificateStat `json:"certificates,omitempty"`
+}
+
+// Metrics keeps collectors in its own registry
+type Metrics struct {
+	registry          *prometheus.Registry
+	renders           *prometheus.CounterVec
+	renderDuration    *prometheus.HistogramVec
+	secretsEmitted    *prometheus.CounterVec
+	secretsSkipped    *prometheus.CounterVec
+	apiErrors         *prometheus.CounterVec
+	cacheRequests     *prometheus.CounterVec
+	certificateExpiry *prometheus.GaugeVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		renders: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "renders_total",
+			Help:      "Renders by application, project and result (success, error, stale).",
+		}, []string{"app", "project", "result"}),
+		renderDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "render_duration_seconds",
+			Help:      "Duration of renders by application and project.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"app", "project"}),
+		secretsEmitted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "secrets_emitted_total",
+			Help:      "Secrets replicated by application and project.",
+		}, []string{"app", "project"}),
+		secretsSkipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "secrets_skipped_total",
+			Help:      "Candidate secrets not replicated by application, project and reason (namespace, policy, invalid, retired, policy-denied, removal-denied).",
+		}, []string{"app", "project", "reason"}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "api_errors_total",
+			Help:      "Renders failed by API errors by reason (unreachable, forbidden, other).",
+		}, []string{"reason"}),
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_requests_total",
+			Help:      "Requests of renders to the secrets cache by result (hit, miss).",
+		}, []string{"result"}),
+		certificateExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "certificate_expiry_timestamp_seconds",
+			Help:      "Earliest expiry of certificates in replicated secrets by app, source namespace, name and destination namespace.",
+		}, []string{"app", "namespace", "name", "destination"}),
+	}
+	m.registry.MustRegister(
+		m.renders,
+		m.renderDuration,
+		m.secretsEmitted,
+		m.secretsSkipped,
+		m.apiErrors,
+		m.cacheRequests,
+		m.certificateExpiry,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Observe records a render report
+func (m *Metrics) Observe(report Report) {
+	m.renders.WithLabelValues(report.App, report.Project, report.Result).Inc()
+	m.renderDuration.WithLabelValues(report.App, report.Project).Observe(report.DurationSeconds)
+	m.secretsEmitted.WithLabelValues(report.App, report.Project).Add(float64(report.Emitted))
+	for reason, count := range report.Skipped {
+		m.secretsSkipped.WithLabelValues(report.App, report.Project, reason).Add(float64(count))
+	}
+	switch report.ErrorReason {
+	case "":
+	// Refusals failed the render on purpose, they are not API errors
+	case k8s.ErrorReasonPolicyDenied, k8s.ErrorReasonRemovalDenied:
+		m.secretsSkipped.WithLabelValues(report.App, report.Project, report.ErrorReason).Inc()
+	default:
+		m.apiErrors.WithLabelValues(report.ErrorReason).Inc()
+	}
+	m.cacheRequests.WithLabelValues("hit").Add(float64(report.CacheHits))
+	m.cacheRequests.WithLabelValues("miss").Add(float64(report.CacheMisses))
+
+	// Only a successful render knows what is replicated now, forget certificates that are no longer there.
+	// Other Applications may render into the same destination, their certificates are theirs to forget.
+	if report.Result == ResultSuccess && report.Destination != "" {
+		m.certificateExpiry.DeletePartialMatch(prometheus.Labels{"app": report.App, "destination": report.Destination})
+		for _, certificate := range report.Certificates {
+			m.certificateExpiry.WithLabelValues(report.App, certificate.Namespace, certificate.Name, report.Destination).Set(float64(certificate.NotAfter.Unix()))
+		}
+	}
+}
+
+// Handler serves metrics in the Prometheus format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Serve exposes /metrics on the address until the context is done
+func (m *Metrics) Serve(ctx context.Context, address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m.Handler())
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving metrics", "address", address)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserve(t *testing.T) {
+	m := New()
+	notAfter := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	m.Observe(Report{
+		App:             "my-app",
+		Project:         "default",
+		Destination:     "my-test-namespace",
+		Result:          ResultSuccess,
+		DurationSeconds: 0.2,
+		Emitted:         3,
+		Skipped: map[string]int{
+			k8s.SkipReasonNamespace: 10,
+			k8s.SkipReasonInvalid:   1,
+		},
+		CacheHits: 2,
+		Certificates: []k8s.CertificateStat{
+			{Namespace: "certs", Name: "wildcard-tls", NotAfter: notAfter},
+			{Namespace: "certs", Name: "old-tls", NotAfter: notAfter},
+		},
+	})
+	m.Observe(Report{
+		App:         "my-app",
+		Project:     "default",
+		Destination: "my-test-namespace",
+		Result:      ResultStale,
+		ErrorReason: k8s.ErrorReasonUnreachable,
+		CacheMisses: 1,
+	})
+	m.Observe(Report{
+		App:         "my-app",
+		Project:     "default",
+		Destination: "my-test-namespace",
+		Result:      ResultError,
+		ErrorReason: k8s.ErrorReasonPolicyDenied,
+	})
+	m.Observe(Report{
+		App:         "my-app",
+		Project:     "default",
+		Destination: "my-test-namespace",
+		Result:      ResultError,
+		ErrorReason: k8s.ErrorReasonRemovalDenied,
+	})
+
+	require.Equal(t, 1.0, testutil.ToFloat64(m.renders.WithLabelValues("my-app", "default", ResultSuccess)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.renders.WithLabelValues("my-app", "default", ResultStale)))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.renders.WithLabelValues("my-app", "default", ResultError)))
+	require.Equal(t, 3.0, testutil.ToFloat64(m.secretsEmitted.WithLabelValues("my-app", "default")))
+	require.Equal(t, 10.0, testutil.ToFloat64(m.secretsSkipped.WithLabelValues("my-app", "default", k8s.SkipReasonNamespace)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.secretsSkipped.WithLabelValues("my-app", "default", k8s.SkipReasonInvalid)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.secretsSkipped.WithLabelValues("my-app", "default", k8s.ErrorReasonPolicyDenied)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.secretsSkipped.WithLabelValues("my-app", "default", k8s.ErrorReasonRemovalDenied)))
+	// Refusals are not API errors
+	require.Equal(t, 1, testutil.CollectAndCount(m.apiErrors))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.apiErrors.WithLabelValues(k8s.ErrorReasonUnreachable)))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.cacheRequests.WithLabelValues("hit")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.cacheRequests.WithLabelValues("miss")))
+	require.Equal(t, 1, testutil.CollectAndCount(m.renderDuration))
+	// A failed render does not forget certificates
+	require.Equal(t, 2, testutil.CollectAndCount(m.certificateExpiry))
+	require.Equal(t, float64(notAfter.Unix()), testutil.ToFloat64(m.certificateExpiry.WithLabelValues("my-app", "certs", "wildcard-tls", "my-test-namespace")))
+
+	m.Observe(Report{
+		App:         "my-app",
+		Project:     "default",
+		Destination: "my-test-namespace",
+		Result:      ResultSuccess,
+		Certificates: []k8s.CertificateStat{
+			{Namespace: "certs", Name: "wildcard-tls", NotAfter: notAfter},
+		},
+	})
+	require.Equal(t, 1, testutil.CollectAndCount(m.certificateExpiry))
+}
+
+func TestObserveCertificatesOfAppsSharingDestination(t *testing.T) {
+	m := New()
+	notAfter := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, app := range []string{"app-a", "app-b"} {
+		m.Observe(Report{
+			App:         app,
+			Project:     "default",
+			Destination: "my-test-namespace",
+			Result:      ResultSuccess,
+			Certificates: []k8s.CertificateStat{
+				{Namespace: "certs", Name: app + "-tls", NotAfter: notAfter},
+			},
+		})
+	}
+	require.Equal(t, 2, testutil.CollectAndCount(m.certificateExpiry))
+
+	// A render of app-b without certificates only forgets its own
+	m.Observe(Report{
+		App:         "app-b",
+		Project:     "default",
+		Destination: "my-test-namespace",
+		Result:      ResultSuccess,
+	})
+	require.Equal(t, 1, testutil.CollectAndCount(m.certificateExpiry))
+	require.Equal(t, float64(notAfter.Unix()), testutil.ToFloat64(m.certificateExpiry.WithLabelValues("app-a", "certs", "app-a-tls", "my-test-namespace")))
+}
@@ -0,0 +1,98 @@
+package sources
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"filippo.io/age"
+	"github.com/plumber-cd/argocd-cmp-replicator/bundle"
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Bundle reads secrets from a bundle made by the export command in a connected site.
+// The bundle must be signed by one of the trusted keys and exported for the destination namespace.
+type Bundle struct {
+	Path       string
+	Namespace  string
+	keys       []ed25519.PublicKey
+	identities []age.Identity
+}
+
+func NewBundle(path, namespace string, keys []ed25519.PublicKey, identities []age.Identity) *Bundle {
+	return &Bundle{
+		Path:       path,
+		Namespace:  namespace,
+		keys:       keys,
+		identities: identities,
+	}
+}
+
+// ListCandidates implements k8s.SecretSource
+func (b *Bundle) ListCandidates(ctx context.Context, labelSelector string) ([]corev1.Secret, error) {
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err := b.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return FilterByLabels(secrets, selector), nil
+}
+
+// Get implements k8s.SecretSource
+func (b *Bundle) Get(ctx context.Context, ref k8s.SecretReference) (*corev1.Secret, error) {
+	secrets, err := b.read()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, secret := range secrets {
+		if secret.Namespace == ref.Namespace && secret.Name == ref.Name {
+			return &secret, nil
+		}
+	}
+
+	return nil, apierrors.NewNotFound(corev1.Resource("secrets"), ref.Name)
+}
+
+// Describe implements k8s.SecretSource
+func (b *Bundle) Describe() string {
+	return "bundle " + b.Path
+}
+
+func (b *Bundle) read() ([]corev1.Secret, error) {
+	f, err := os.Open(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sealed, err := bundle.Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle %s: %w", b.Path, err)
+	}
+	if err := sealed.Verify(b.keys); err != nil {
+		return nil, fmt.Errorf("failed to verify bundle %s: %w", b.Path, err)
+	}
+	if sealed.Target != b.Namespace {
+		return nil, fmt.Errorf("bundle %s was exported for namespace %s, not %s", b.Path, sealed.Target, b.Namespace)
+	}
+
+	secrets, err := sealed.Open(b.identities)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle %s: %w", b.Path, err)
+	}
+	slog.Debug("Read secrets from bundle", "path", b.Path, "createdAt", sealed.CreatedAt, "count", len(secrets))
+
+	sortSecrets(secrets)
+	return secrets, nil
+}
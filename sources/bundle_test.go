@@ -0,0 +1,69 @@
+package sources
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/plumber-cd/argocd-cmp-replicator/bundle"
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundle(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	// Export from the connected site, the same way the export command does
+	dir := NewDir("testdata/fixtures")
+	exported, err := (&k8s.Client{
+		Sources: []k8s.SecretSource{dir},
+	}).GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+	require.NoError(t, err)
+	require.NotEmpty(t, exported.Items)
+
+	sealed, err := bundle.Seal(exported.Items, "my-test-namespace", []age.Recipient{identity.Recipient()}, private)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "bundle.json")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, sealed.Write(f))
+	require.NoError(t, f.Close())
+
+	t.Run("render", func(t *testing.T) {
+		source := NewBundle(path, "my-test-namespace", []ed25519.PublicKey{public}, []age.Identity{identity})
+		secrets, err := (&k8s.Client{
+			Sources: []k8s.SecretSource{source},
+		}).GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+		require.Equal(t, len(exported.Items), len(secrets.Items))
+		for i := range exported.Items {
+			require.Equal(t, exported.Items[i].Namespace, secrets.Items[i].Namespace)
+			require.Equal(t, exported.Items[i].Name, secrets.Items[i].Name)
+			require.Equal(t, exported.Items[i].Data, secrets.Items[i].Data)
+		}
+	})
+	t.Run("untrusted", func(t *testing.T) {
+		source := NewBundle(path, "my-test-namespace", []ed25519.PublicKey{otherPublic}, []age.Identity{identity})
+		_, err := source.ListCandidates(context.TODO(), "")
+		require.ErrorIs(t, err, bundle.ErrUntrusted)
+	})
+	t.Run("other-target", func(t *testing.T) {
+		source := NewBundle(path, "some-other-namespace", []ed25519.PublicKey{public}, []age.Identity{identity})
+		_, err := source.ListCandidates(context.TODO(), "")
+		require.ErrorContains(t, err, "was exported for namespace my-test-namespace")
+	})
+	t.Run("missing", func(t *testing.T) {
+		source := NewBundle(filepath.Join(t.TempDir(), "missing.json"), "my-test-namespace", []ed25519.PublicKey{public}, []age.Identity{identity})
+		_, err := source.ListCandidates(context.TODO(), "")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,197 @@
+package sources
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// Dir reads Secret manifests from a file or a directory tree, so renders can be reproduced without a cluster.
+type Dir struct {
+	Path string
+}
+
+func NewDir(path string) *Dir {
+	return &Dir{
+		Path: path,
+	}
+}
+
+// ListCandidates implements k8s.SecretSource
+func (d *Dir) ListCandidates(ctx context.Context, labelSelector string) ([]corev1.Secret, error) {
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err := d.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return FilterByLabels(secrets, selector), nil
+}
+
+// Get implements k8s.SecretSource
+func (d *Dir) Get(ctx context.Context, ref k8s.SecretReference) (*corev1.Secret, error) {
+	secrets, err := d.read()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, secret := range secrets {
+		if secret.Namespace == ref.Namespace && secret.Name == ref.Name {
+			return &secret, nil
+		}
+	}
+
+	return nil, apierrors.NewNotFound(corev1.Resource("secrets"), ref.Name)
+}
+
+// Describe implements k8s.SecretSource
+func (d *Dir) Describe() string {
+	return "dir " + d.Path
+}
+
+func (d *Dir) read() ([]corev1.Secret, error) {
+	secrets := []corev1.Secret{}
+	err := filepath.WalkDir(d.Path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+		default:
+			slog.Debug("Skipped file with unknown extension", "path", path)
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		fileSecrets, err := ParseSecrets(f)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		slog.Debug("Read secrets from file", "path", path, "count", len(fileSecrets))
+		secrets = append(secrets, fileSecrets...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortSecrets(secrets)
+	return secrets, nil
+}
+
+// ParseSecrets reads multi-document YAML or JSON with Secrets or Lists of Secrets, other kinds are ignored.
+// Secrets are normalized the way the API server would do it, so they look the same as the ones read from a cluster.
+func ParseSecrets(r io.Reader) ([]corev1.Secret, error) {
+	secrets := []corev1.Secret{}
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		typeMeta := metav1.TypeMeta{}
+		if err := yaml.Unmarshal(doc, &typeMeta); err != nil {
+			return nil, err
+		}
+
+		switch typeMeta.Kind {
+		case "Secret":
+			secret := corev1.Secret{}
+			if err := yaml.Unmarshal(doc, &secret); err != nil {
+				return nil, err
+			}
+			secrets = append(secrets, normalizeSecret(secret))
+		case "List", "SecretList":
+			list := struct {
+				Items []corev1.Secret `json:"items"`
+			}{}
+			if err := yaml.Unmarshal(doc, &list); err != nil {
+				return nil, err
+			}
+			for _, secret := range list.Items {
+				if secret.Kind != "" && secret.Kind != "Secret" {
+					continue
+				}
+				secrets = append(secrets, normalizeSecret(secret))
+			}
+		case "":
+			// Empty document
+		default:
+			slog.Debug("Skipped document", "kind", typeMeta.Kind)
+		}
+	}
+
+	return secrets, nil
+}
+
+func normalizeSecret(secret corev1.Secret) corev1.Secret {
+	if secret.Namespace == "" {
+		secret.Namespace = metav1.NamespaceDefault
+	}
+	if secret.Type == "" {
+		secret.Type = corev1.SecretTypeOpaque
+	}
+	if len(secret.StringData) > 0 {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		for k, v := range secret.StringData {
+			secret.Data[k] = []byte(v)
+		}
+		secret.StringData = nil
+	}
+	return secret
+}
+
+// sortSecrets orders secrets the same way the API server lists them
+func sortSecrets(secrets []corev1.Secret) {
+	sort.SliceStable(secrets, func(i, j int) bool {
+		if secrets[i].Namespace != secrets[j].Namespace {
+			return secrets[i].Namespace < secrets[j].Namespace
+		}
+		return secrets[i].Name < secrets[j].Name
+	})
+}
+
+// FilterByLabels returns secrets matching the selector, for sources that can't do it server-side
+func FilterByLabels(secrets []corev1.Secret, selector labels.Selector) []corev1.Secret {
+	filtered := []corev1.Secret{}
+	for _, secret := range secrets {
+		if selector.Matches(labels.Set(secret.Labels)) {
+			filtered = append(filtered, secret)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,83 @@
+package sources
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"strings"
+	"testing"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+//go:embed testdata/expected.yaml
+var expectedYAML string
+
+func TestDir(t *testing.T) {
+	dir := NewDir("testdata/fixtures")
+
+	t.Run("list-candidates", func(t *testing.T) {
+		secrets, err := dir.ListCandidates(context.TODO(), types.ReplicatorLabel+"=true")
+		require.NoError(t, err)
+
+		secretKeys := []string{}
+		for _, secret := range secrets {
+			secretKeys = append(secretKeys, secret.Namespace+"/"+secret.Name)
+		}
+		require.Equal(t, []string{
+			"my-test-namespace/a-labeled-pull-secret",
+			"my-test-namespace/labeled-secret",
+			"some-other-namespace/labeled-secret-for-another-namespace",
+			"some-other-namespace/labeled-secret-for-any-namespace",
+		}, secretKeys)
+	})
+	t.Run("get", func(t *testing.T) {
+		secret, err := dir.Get(context.TODO(), k8s.SecretReference{Namespace: "my-test-namespace", Name: "not-labeled"})
+		require.NoError(t, err)
+		require.Equal(t, "value", string(secret.Data["key"]))
+
+		_, err = dir.Get(context.TODO(), k8s.SecretReference{Namespace: "my-test-namespace", Name: "not-a-secret"})
+		require.Error(t, err)
+	})
+	t.Run("missing", func(t *testing.T) {
+		_, err := NewDir("testdata/missing").ListCandidates(context.TODO(), "")
+		require.Error(t, err)
+	})
+}
+
+func TestDirRender(t *testing.T) {
+	render := func(client *k8s.Client) string {
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-test-namespace", "")
+		require.NoError(t, err)
+
+		buf := bytes.NewBufferString("")
+		require.NoError(t, client.WriteSecretListManifests(context.TODO(), "my-test-namespace", secrets, buf))
+		return buf.String()
+	}
+
+	dir := NewDir("testdata/fixtures")
+	fromDir := render(&k8s.Client{
+		Sources: []k8s.SecretSource{dir},
+	})
+	require.Equal(t, expectedYAML, fromDir)
+
+	// Same secrets read from a cluster must render the same
+	all, err := dir.read()
+	require.NoError(t, err)
+	objects := []runtime.Object{}
+	for i := range all {
+		objects = append(objects, &all[i])
+	}
+	fromCluster := render(&k8s.Client{
+		Interface: testClient.NewSimpleClientset(objects...),
+	})
+
+	// Fake clientset does not preserve the order the real API server would have
+	require.ElementsMatch(t, strings.Split(fromDir, "---\n"), strings.Split(fromCluster, "---\n"))
+}
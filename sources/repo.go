@@ -0,0 +1,150 @@
+package sources
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// RepoExtension is the extension of encrypted manifests the Repo source reads
+const RepoExtension = ".age"
+
+// Repo reads age-encrypted Secret manifests committed next to the Application, i.e. in the CMP working directory.
+// These secrets belong to the Application, so they are always placed in its destination namespace.
+type Repo struct {
+	Path       string
+	Namespace  string
+	identities []age.Identity
+}
+
+// NewRepo creates a source for encrypted manifests under path, that belong to the destination namespace
+func NewRepo(path, namespace string, identities []age.Identity) *Repo {
+	return &Repo{
+		Path:       path,
+		Namespace:  namespace,
+		identities: identities,
+	}
+}
+
+// AgeIdentities parses age identities stored under the key in the secret, one per line
+func AgeIdentities(secret *corev1.Secret, key string) ([]age.Identity, error) {
+	data, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in secret %s/%s", key, secret.Namespace, secret.Name)
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse age identities from secret %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
+	return identities, nil
+}
+
+// ListCandidates implements k8s.SecretSource
+func (r *Repo) ListCandidates(ctx context.Context, labelSelector string) ([]corev1.Secret, error) {
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return FilterByLabels(secrets, selector), nil
+}
+
+// Get implements k8s.SecretSource
+func (r *Repo) Get(ctx context.Context, ref k8s.SecretReference) (*corev1.Secret, error) {
+	secrets, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, secret := range secrets {
+		if secret.Namespace == ref.Namespace && secret.Name == ref.Name {
+			return &secret, nil
+		}
+	}
+
+	return nil, apierrors.NewNotFound(corev1.Resource("secrets"), ref.Name)
+}
+
+// Describe implements k8s.SecretSource
+func (r *Repo) Describe() string {
+	return "repo " + r.Path
+}
+
+func (r *Repo) read() ([]corev1.Secret, error) {
+	secrets := []corev1.Secret{}
+	err := filepath.WalkDir(r.Path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || filepath.Ext(path) != RepoExtension {
+			return nil
+		}
+
+		fileSecrets, err := r.decrypt(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		slog.Debug("Read encrypted secrets from file", "path", path, "count", len(fileSecrets))
+		secrets = append(secrets, fileSecrets...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Namespace in the manifest is ignored, the repo can only speak for its own Application.
+	// Secrets are labeled for the default selector unless they opted in to the alternative one.
+	for i := range secrets {
+		secrets[i].Namespace = r.Namespace
+		if secrets[i].Labels == nil {
+			secrets[i].Labels = map[string]string{}
+		}
+		if secrets[i].Labels[types.ReplicatorLabelAlternative] == "" {
+			secrets[i].Labels[types.ReplicatorLabel] = "true"
+		}
+	}
+
+	sortSecrets(secrets)
+	return secrets, nil
+}
+
+func (r *Repo) decrypt(path string) ([]corev1.Secret, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Both binary and ASCII-armored (age -a) files are accepted
+	var in io.Reader = bufio.NewReader(f)
+	header, err := in.(*bufio.Reader).Peek(len(armor.Header))
+	if err == nil && string(header) == armor.Header {
+		in = armor.NewReader(in)
+	}
+
+	plaintext, err := age.Decrypt(in, r.identities...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSecrets(plaintext)
+}
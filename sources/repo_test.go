@@ -0,0 +1,141 @@
+package sources
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func writeEncrypted(t *testing.T, path string, recipient age.Recipient, armored bool, manifest string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var out io.Writer = f
+	if armored {
+		a := armor.NewWriter(f)
+		defer func() { require.NoError(t, a.Close()) }()
+		out = a
+	}
+	w, err := age.Encrypt(out, recipient)
+	require.NoError(t, err)
+	_, err = io.WriteString(w, manifest)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+}
+
+func TestRepo(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	path := t.TempDir()
+	writeEncrypted(t, filepath.Join(path, "secrets.yaml.age"), identity.Recipient(), true, `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: api-key
+  namespace: somewhere-else
+stringData:
+  key: value
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  annotations:
+    plumber-cd.github.io/argocd-cmp-replicator-replicated-name: db-credentials
+data:
+  password: cGFzc3dvcmQ=
+`)
+	writeEncrypted(t, filepath.Join(path, "nested", "alternative.age"), identity.Recipient(), false, `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: alternative
+  labels:
+    plumber-cd.github.io/argocd-cmp-replicator-use-alternative-selector: "true"
+    team: platform
+`)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "plain.yaml"), []byte("kind: Secret\nmetadata:\n  name: plain\n"), 0600))
+
+	repo := NewRepo(path, "my-app", []age.Identity{identity})
+
+	t.Run("list-candidates", func(t *testing.T) {
+		secrets, err := repo.ListCandidates(context.TODO(), types.ReplicatorLabel+"=true")
+		require.NoError(t, err)
+		require.Len(t, secrets, 2)
+
+		require.Equal(t, "api-key", secrets[0].Name)
+		require.Equal(t, "my-app", secrets[0].Namespace)
+		require.Equal(t, "value", string(secrets[0].Data["key"]))
+		require.Equal(t, "db", secrets[1].Name)
+		require.Equal(t, "password", string(secrets[1].Data["password"]))
+	})
+	t.Run("alternative-label-selector", func(t *testing.T) {
+		secrets, err := repo.ListCandidates(context.TODO(), types.ReplicatorLabelAlternative+"=true,team=platform")
+		require.NoError(t, err)
+		require.Len(t, secrets, 1)
+		require.Equal(t, "alternative", secrets[0].Name)
+	})
+	t.Run("get", func(t *testing.T) {
+		secret, err := repo.Get(context.TODO(), k8s.SecretReference{Namespace: "my-app", Name: "db"})
+		require.NoError(t, err)
+		require.Equal(t, "db-credentials", secret.Annotations[types.ReplicatorAnnotationReplicatedName])
+
+		_, err = repo.Get(context.TODO(), k8s.SecretReference{Namespace: "somewhere-else", Name: "api-key"})
+		require.Error(t, err)
+	})
+	t.Run("render", func(t *testing.T) {
+		client := &k8s.Client{
+			Sources: []k8s.SecretSource{repo},
+		}
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "my-app", "")
+		require.NoError(t, err)
+		require.Len(t, secrets.Items, 2)
+	})
+	t.Run("wrong-identity", func(t *testing.T) {
+		other, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+
+		_, err = NewRepo(path, "my-app", []age.Identity{other}).ListCandidates(context.TODO(), "")
+		require.ErrorContains(t, err, "no identity matched")
+	})
+}
+
+func TestAgeIdentities(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "argocd-cmp-replicator-age",
+			Namespace: "argocd",
+		},
+		Data: map[string][]byte{
+			"keys.txt": []byte("# created: 2024-01-01T00:00:00Z\n" + identity.String() + "\n"),
+			"broken":   []byte("not an identity"),
+		},
+	}
+
+	identities, err := AgeIdentities(secret, "keys.txt")
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+
+	_, err = AgeIdentities(secret, "broken")
+	require.Error(t, err)
+
+	_, err = AgeIdentities(secret, "missing")
+	require.ErrorContains(t, err, "key missing not found")
+}
@@ -0,0 +1,359 @@
+package sources
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// VaultMetadataNamespace is custom metadata key with the namespace the secret belongs to, as if it was in the cluster
+	VaultMetadataNamespace = "namespace"
+	// VaultMetadataType is custom metadata key with the secret type, Opaque by default
+	VaultMetadataType = "type"
+	// VaultMetadataLabelPrefix custom metadata keys with this prefix become labels
+	VaultMetadataLabelPrefix = "label."
+)
+
+// vaultMetadataAnnotations maps custom metadata keys to the annotations with the same meaning
+var vaultMetadataAnnotations = map[string]string{
+	"allowed-namespaces": types.ReplicatorAnnotationAllowedNamespaces,
+	"replicated-name":    types.ReplicatorAnnotationReplicatedName,
+	"grant-signature":    types.ReplicatorAnnotationGrantSignature,
+	"not-before":         types.ReplicatorAnnotationNotBefore,
+	"not-after":          types.ReplicatorAnnotationNotAfter,
+	"max-age":            types.ReplicatorAnnotationMaxAge,
+	"rotated-at":         types.ReplicatorAnnotationRotatedAt,
+}
+
+type VaultOptions struct {
+	// Address of the Vault server, i.e. https://vault.example.com:8200
+	Address string
+	// Namespace is Vault Enterprise namespace
+	Namespace string
+	// CAFile to verify Vault server certificate
+	CAFile string
+	// TokenFile if set, is used instead of Kubernetes auth
+	TokenFile string
+	// AuthMount is where Kubernetes auth method is mounted
+	AuthMount string
+	// Role to login with Kubernetes auth
+	Role string
+	// JWTFile is the service account token to login with Kubernetes auth
+	JWTFile string
+}
+
+// Vault reads secrets from KV v2 engine, custom metadata has the same meaning as annotations on the secrets in the cluster.
+type Vault struct {
+	options    VaultOptions
+	mount      string
+	path       string
+	httpClient *http.Client
+	token      string
+}
+
+// NewVault creates a source for all secrets under mountPath, which is the KV v2 mount and optional path in it, i.e. secret/replicated
+func NewVault(options VaultOptions, mountPath string) (*Vault, error) {
+	mount, p, _ := strings.Cut(strings.Trim(mountPath, "/"), "/")
+	if mount == "" {
+		return nil, errors.New("Vault KV v2 mount is not set")
+	}
+	if options.Address == "" {
+		return nil, errors.New("Vault address is not set")
+	}
+	if options.AuthMount == "" {
+		options.AuthMount = "kubernetes"
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.CAFile != "" {
+		ca, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", options.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &Vault{
+		options:    options,
+		mount:      mount,
+		path:       p,
+		httpClient: &http.Client{Transport: transport},
+	}, nil
+}
+
+// ListCandidates implements k8s.SecretSource
+func (v *Vault) ListCandidates(ctx context.Context, labelSelector string) ([]corev1.Secret, error) {
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err := v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return FilterByLabels(secrets, selector), nil
+}
+
+// Get implements k8s.SecretSource
+func (v *Vault) Get(ctx context.Context, ref k8s.SecretReference) (*corev1.Secret, error) {
+	secrets, err := v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, secret := range secrets {
+		if secret.Namespace == ref.Namespace && secret.Name == ref.Name {
+			return &secret, nil
+		}
+	}
+
+	return nil, apierrors.NewNotFound(corev1.Resource("secrets"), ref.Name)
+}
+
+// Describe implements k8s.SecretSource
+func (v *Vault) Describe() string {
+	return fmt.Sprintf("vault %s/%s", v.options.Address, path.Join(v.mount, v.path))
+}
+
+func (v *Vault) read(ctx context.Context) ([]corev1.Secret, error) {
+	if err := v.login(ctx); err != nil {
+		return nil, err
+	}
+
+	paths, err := v.list(ctx, v.path)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := []corev1.Secret{}
+	for _, p := range paths {
+		secret, err := v.readSecret(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		if secret != nil {
+			secrets = append(secrets, *secret)
+		}
+	}
+
+	sortSecrets(secrets)
+	return secrets, nil
+}
+
+func (v *Vault) login(ctx context.Context) error {
+	if v.token != "" {
+		return nil
+	}
+
+	if v.options.TokenFile != "" {
+		token, err := os.ReadFile(v.options.TokenFile)
+		if err != nil {
+			return err
+		}
+		v.token = strings.TrimSpace(string(token))
+		return nil
+	}
+
+	if v.options.Role == "" || v.options.JWTFile == "" {
+		return errors.New("Vault token file or Kubernetes auth role and JWT file must be set")
+	}
+	jwt, err := os.ReadFile(v.options.JWTFile)
+	if err != nil {
+		return err
+	}
+
+	response := struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}{}
+	err = v.do(ctx, http.MethodPost, path.Join("auth", v.options.AuthMount, "login"), map[string]string{
+		"role": v.options.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	}, &response)
+	if err != nil {
+		return fmt.Errorf("Vault Kubernetes auth login failed: %w", err)
+	}
+	if response.Auth.ClientToken == "" {
+		return errors.New("Vault Kubernetes auth login returned no token")
+	}
+
+	slog.Debug("Logged in to Vault", "authMount", v.options.AuthMount, "role", v.options.Role)
+	v.token = response.Auth.ClientToken
+	return nil
+}
+
+// list recursively returns paths of all secrets under p
+func (v *Vault) list(ctx context.Context, p string) ([]string, error) {
+	response := struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}{}
+	err := v.do(ctx, "LIST", path.Join(v.mount, "metadata", p), nil, &response)
+	if errors.Is(err, errVaultNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for _, key := range response.Data.Keys {
+		if strings.HasSuffix(key, "/") {
+			nested, err := v.list(ctx, path.Join(p, key))
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, nested...)
+			continue
+		}
+		paths = append(paths, path.Join(p, key))
+	}
+	return paths, nil
+}
+
+// readSecret returns nil if the current version is deleted or required metadata is missing
+func (v *Vault) readSecret(ctx context.Context, p string) (*corev1.Secret, error) {
+	metadata := struct {
+		Data struct {
+			CustomMetadata map[string]string `json:"custom_metadata"`
+		} `json:"data"`
+	}{}
+	if err := v.do(ctx, http.MethodGet, path.Join(v.mount, "metadata", p), nil, &metadata); err != nil {
+		return nil, err
+	}
+
+	data := struct {
+		Data struct {
+			Data     map[string]interface{} `json:"data"`
+			Metadata struct {
+				CreatedTime string `json:"created_time"`
+				Version     int    `json:"version"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}{}
+	err := v.do(ctx, http.MethodGet, path.Join(v.mount, "data", p), nil, &data)
+	if errors.Is(err, errVaultNotFound) || (err == nil && data.Data.Data == nil) {
+		slog.Debug("Skipped deleted Vault secret", "path", p)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	customMetadata := metadata.Data.CustomMetadata
+	namespace := customMetadata[VaultMetadataNamespace]
+	if namespace == "" {
+		slog.Warn("Skipped Vault secret without namespace in custom metadata", "path", p)
+		return nil, nil
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      path.Base(p),
+			Namespace: namespace,
+			Labels: map[string]string{
+				types.ReplicatorLabel: "true",
+			},
+			Annotations: map[string]string{},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{},
+	}
+	if t := customMetadata[VaultMetadataType]; t != "" {
+		secret.Type = corev1.SecretType(t)
+	}
+	if data.Data.Metadata.CreatedTime != "" {
+		secret.Annotations[types.ReplicatorAnnotationRotatedAt] = data.Data.Metadata.CreatedTime
+	}
+	for k, value := range customMetadata {
+		if label, ok := strings.CutPrefix(k, VaultMetadataLabelPrefix); ok {
+			secret.Labels[label] = value
+		}
+		if annotation, ok := vaultMetadataAnnotations[k]; ok {
+			secret.Annotations[annotation] = value
+		}
+	}
+	for k, value := range data.Data.Data {
+		if s, ok := value.(string); ok {
+			secret.Data[k] = []byte(s)
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		secret.Data[k] = encoded
+	}
+
+	slog.Debug("Read Vault secret", "path", p, "version", data.Data.Metadata.Version, "namespace", namespace)
+	return secret, nil
+}
+
+var errVaultNotFound = errors.New("not found")
+
+func (v *Vault) do(ctx context.Context, method, p string, body interface{}, into interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(v.options.Address, "/")+"/v1/"+p, reader)
+	if err != nil {
+		return err
+	}
+	if v.token != "" {
+		request.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.options.Namespace != "" {
+		request.Header.Set("X-Vault-Namespace", v.options.Namespace)
+	}
+
+	response, err := v.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return errVaultNotFound
+	}
+	if response.StatusCode != http.StatusOK {
+		errorsResponse := struct {
+			Errors []string `json:"errors"`
+		}{}
+		_ = json.NewDecoder(response.Body).Decode(&errorsResponse)
+		return fmt.Errorf("%s %s: %s %v", method, p, response.Status, errorsResponse.Errors)
+	}
+
+	return json.NewDecoder(response.Body).Decode(into)
+}
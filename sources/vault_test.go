@@ -0,0 +1,218 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/plumber-cd/argocd-cmp-replicator/k8s"
+	"github.com/plumber-cd/argocd-cmp-replicator/types"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// newVaultStandIn serves just enough of KV v2 and Kubernetes auth APIs
+func newVaultStandIn(t *testing.T) *httptest.Server {
+	type kvSecret struct {
+		customMetadata map[string]string
+		data           map[string]interface{}
+	}
+	secrets := map[string]kvSecret{
+		"replicated/db": {
+			customMetadata: map[string]string{
+				"namespace":          "databases",
+				"allowed-namespaces": "migration",
+				"replicated-name":    "db-credentials",
+			},
+			data: map[string]interface{}{
+				"username": "admin",
+				"port":     5432,
+			},
+		},
+		"replicated/registry/ghcr": {
+			customMetadata: map[string]string{
+				"namespace":       "my-test-namespace",
+				"type":            "kubernetes.io/dockerconfigjson",
+				"label.team":      "platform",
+				"rotated-at":      "2024-02-01T00:00:00Z",
+				"unrelated-entry": "foo",
+			},
+			data: map[string]interface{}{
+				".dockerconfigjson": `{"auths":{}}`,
+			},
+		},
+		"replicated/no-namespace": {
+			customMetadata: map[string]string{},
+			data:           map[string]interface{}{"foo": "bar"},
+		},
+		"replicated/deleted": {
+			customMetadata: map[string]string{
+				"namespace": "my-test-namespace",
+			},
+		},
+	}
+
+	write := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(v))
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth/kubernetes/login" {
+			body := map[string]string{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			if body["role"] != "argocd-cmp-replicator" || body["jwt"] != "some-jwt" {
+				w.WriteHeader(http.StatusForbidden)
+				write(w, map[string]interface{}{"errors": []string{"permission denied"}})
+				return
+			}
+			write(w, map[string]interface{}{"auth": map[string]string{"client_token": "some-token"}})
+			return
+		}
+
+		if r.Header.Get("X-Vault-Token") != "some-token" {
+			w.WriteHeader(http.StatusForbidden)
+			write(w, map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+
+		if p, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/metadata/"); ok && r.Method == "LIST" {
+			prefix := strings.TrimSuffix(p, "/") + "/"
+			keys := []string{}
+			for name := range secrets {
+				if rest, ok := strings.CutPrefix(name, prefix); ok {
+					if dir, _, nested := strings.Cut(rest, "/"); nested {
+						rest = dir + "/"
+					}
+					keys = append(keys, rest)
+				}
+			}
+			if len(keys) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			write(w, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+			return
+		}
+		if p, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/metadata/"); ok {
+			write(w, map[string]interface{}{"data": map[string]interface{}{"custom_metadata": secrets[p].customMetadata}})
+			return
+		}
+		if p, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/data/"); ok {
+			write(w, map[string]interface{}{"data": map[string]interface{}{
+				"data": secrets[p].data,
+				"metadata": map[string]interface{}{
+					"created_time": "2024-03-01T00:00:00.123456Z",
+					"version":      1,
+				},
+			}})
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+}
+
+func TestVault(t *testing.T) {
+	server := newVaultStandIn(t)
+	defer server.Close()
+
+	jwtFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(jwtFile, []byte("some-jwt\n"), 0600))
+
+	vault, err := NewVault(VaultOptions{
+		Address: server.URL,
+		Role:    "argocd-cmp-replicator",
+		JWTFile: jwtFile,
+	}, "secret/replicated")
+	require.NoError(t, err)
+
+	t.Run("list-candidates", func(t *testing.T) {
+		secrets, err := vault.ListCandidates(context.TODO(), types.ReplicatorLabel+"=true")
+		require.NoError(t, err)
+		require.Len(t, secrets, 2)
+
+		db := secrets[0]
+		require.Equal(t, "databases", db.Namespace)
+		require.Equal(t, "db", db.Name)
+		require.Equal(t, corev1.SecretTypeOpaque, db.Type)
+		require.Equal(t, "migration", db.Annotations[types.ReplicatorAnnotationAllowedNamespaces])
+		require.Equal(t, "db-credentials", db.Annotations[types.ReplicatorAnnotationReplicatedName])
+		require.Equal(t, "2024-03-01T00:00:00.123456Z", db.Annotations[types.ReplicatorAnnotationRotatedAt])
+		require.Equal(t, map[string][]byte{
+			"username": []byte("admin"),
+			"port":     []byte("5432"),
+		}, db.Data)
+
+		ghcr := secrets[1]
+		require.Equal(t, "my-test-namespace", ghcr.Namespace)
+		require.Equal(t, "ghcr", ghcr.Name)
+		require.Equal(t, corev1.SecretTypeDockerConfigJson, ghcr.Type)
+		require.Equal(t, "platform", ghcr.Labels["team"])
+		require.Equal(t, "2024-02-01T00:00:00Z", ghcr.Annotations[types.ReplicatorAnnotationRotatedAt])
+		require.NotContains(t, ghcr.Annotations, "unrelated-entry")
+	})
+	t.Run("label-selector", func(t *testing.T) {
+		secrets, err := vault.ListCandidates(context.TODO(), "team=platform")
+		require.NoError(t, err)
+		require.Len(t, secrets, 1)
+		require.Equal(t, "ghcr", secrets[0].Name)
+	})
+	t.Run("get", func(t *testing.T) {
+		secret, err := vault.Get(context.TODO(), k8s.SecretReference{Namespace: "databases", Name: "db"})
+		require.NoError(t, err)
+		require.Equal(t, "admin", string(secret.Data["username"]))
+	})
+	t.Run("render", func(t *testing.T) {
+		client := &k8s.Client{
+			Sources: []k8s.SecretSource{vault},
+		}
+		secrets, err := client.GetLabeledSecrets(context.TODO(), "migration", "")
+		require.NoError(t, err)
+		require.Len(t, secrets.Items, 1)
+		require.Equal(t, "db", secrets.Items[0].Name)
+	})
+	t.Run("empty-path", func(t *testing.T) {
+		empty, err := NewVault(VaultOptions{
+			Address: server.URL,
+			Role:    "argocd-cmp-replicator",
+			JWTFile: jwtFile,
+		}, "secret/missing")
+		require.NoError(t, err)
+
+		secrets, err := empty.ListCandidates(context.TODO(), "")
+		require.NoError(t, err)
+		require.Empty(t, secrets)
+	})
+	t.Run("login-failed", func(t *testing.T) {
+		denied, err := NewVault(VaultOptions{
+			Address: server.URL,
+			Role:    "some-other-role",
+			JWTFile: jwtFile,
+		}, "secret/replicated")
+		require.NoError(t, err)
+
+		_, err = denied.ListCandidates(context.TODO(), "")
+		require.ErrorContains(t, err, "permission denied")
+	})
+	t.Run("token-file", func(t *testing.T) {
+		tokenFile := filepath.Join(t.TempDir(), "vault-token")
+		require.NoError(t, os.WriteFile(tokenFile, []byte("some-token"), 0600))
+
+		withToken, err := NewVault(VaultOptions{
+			Address:   server.URL,
+			TokenFile: tokenFile,
+		}, "secret/replicated")
+		require.NoError(t, err)
+
+		secrets, err := withToken.ListCandidates(context.TODO(), "")
+		require.NoError(t, err)
+		require.Len(t, secrets, 2)
+	})
+}
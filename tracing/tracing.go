@@ -0,0 +1,126 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone = "none"
+	// ExporterOTLP is configured with the standard OTEL_EXPORTER_OTLP_* environment variables
+	ExporterOTLP = "otlp"
+	// ExporterStderr writes spans as JSON to stderr, stdout is the render output
+	ExporterStderr = "stderr"
+	// ExporterFile appends spans as JSON to a file
+	ExporterFile = "file"
+)
+
+// Attributes never carry secret data, only names of things and counts
+const (
+	AttributeApp         = attribute.Key("argocd.app.name")
+	AttributeProject     = attribute.Key("argocd.app.project")
+	AttributeDestination = attribute.Key("argocd.app.destination.namespace")
+	AttributeSource      = attribute.Key("replicator.source")
+	AttributeCandidates  = attribute.Key("replicator.secrets.candidates")
+	AttributeKept        = attribute.Key("replicator.secrets.kept")
+	AttributeSecrets     = attribute.Key("replicator.secrets.count")
+	AttributePage        = attribute.Key("replicator.page")
+)
+
+const name = "github.com/plumber-cd/argocd-cmp-replicator"
+
+// Tracer is a no-op until Setup is called
+func Tracer() trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// End records the error, if any, and ends the span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Options configure where spans are exported to
+type Options struct {
+	// Exporter is one of Exporter*, spans are not recorded if empty
+	Exporter string
+	// File is where ExporterFile appends spans to
+	File string
+	// Version of the binary recorded on spans
+	Version string
+}
+
+// Setup installs the global tracer provider with the exporter.
+// Returned function flushes spans and must be called before the process exits.
+func Setup(ctx context.Context, options Options) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var closer io.Closer
+	switch options.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		otlp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		spanExporter = otlp
+	case ExporterStderr:
+		stderr, err := stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+		if err != nil {
+			return nil, err
+		}
+		spanExporter = stderr
+	case ExporterFile:
+		if options.File == "" {
+			return nil, fmt.Errorf("trace file must be set for the %s exporter", ExporterFile)
+		}
+		f, err := os.OpenFile(options.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		toFile, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		spanExporter, closer = toFile, f
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %s", options.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		// Renders are short-lived, batching would only delay the flush
+		sdktrace.WithSyncer(spanExporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName("argocd-cmp-replicator"),
+			semconv.ServiceVersion(options.Version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
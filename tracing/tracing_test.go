@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	t.Run("none", func(t *testing.T) {
+		shutdown, err := Setup(context.TODO(), Options{})
+		require.NoError(t, err)
+		require.NoError(t, shutdown(context.TODO()))
+	})
+	t.Run("unknown", func(t *testing.T) {
+		_, err := Setup(context.TODO(), Options{Exporter: "jaeger"})
+		require.ErrorContains(t, err, "unknown trace exporter")
+	})
+	t.Run("file-requires-path", func(t *testing.T) {
+		_, err := Setup(context.TODO(), Options{Exporter: ExporterFile})
+		require.Error(t, err)
+	})
+	t.Run("file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "spans.json")
+		shutdown, err := Setup(context.TODO(), Options{Exporter: ExporterFile, File: file, Version: "test"})
+		require.NoError(t, err)
+
+		ctx, parent := Tracer().Start(context.TODO(), "render")
+		parent.SetAttributes(AttributeApp.String("my-app"))
+		_, child := Tracer().Start(ctx, "list")
+		End(child, os.ErrNotExist)
+		End(parent, nil)
+		require.NoError(t, shutdown(context.TODO()))
+
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		spans := []map[string]interface{}{}
+		decoder := json.NewDecoder(strings.NewReader(string(data)))
+		for decoder.More() {
+			span := map[string]interface{}{}
+			require.NoError(t, decoder.Decode(&span))
+			spans = append(spans, span)
+		}
+		require.Len(t, spans, 2)
+		require.Equal(t, "list", spans[0]["Name"])
+		require.Equal(t, "Error", spans[0]["Status"].(map[string]interface{})["Code"])
+		require.Equal(t, "render", spans[1]["Name"])
+		require.Contains(t, string(data), "my-app")
+		require.Contains(t, string(data), "argocd-cmp-replicator")
+	})
+}
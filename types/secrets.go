@@ -3,7 +3,24 @@ package types
 const (
 	ReplicatorLabel                       = "plumber-cd.github.io/argocd-cmp-replicator"
 	ReplicatorLabelAlternative            = "plumber-cd.github.io/argocd-cmp-replicator-use-alternative-selector"
+	ReplicatorIndexLabelPrefix            = "plumber-cd.github.io/argocd-cmp-replicator-to-ns."
+	ReplicatorIndexLabelWildcard          = "plumber-cd.github.io/argocd-cmp-replicator-to-all"
 	ReplicatorAnnotationAllowedNamespaces = "plumber-cd.github.io/argocd-cmp-replicator-allowed-namespaces"
 	ReplicatorAnnotationFromNamespace     = "plumber-cd.github.io/argocd-cmp-replicator-from-namespace"
+	ReplicatorAnnotationFromCluster       = "plumber-cd.github.io/argocd-cmp-replicator-from-cluster"
 	ReplicatorAnnotationReplicatedName    = "plumber-cd.github.io/argocd-cmp-replicator-replicated-name"
+	ReplicatorAnnotationGrantSignature    = "plumber-cd.github.io/argocd-cmp-replicator-grant-signature"
+	ReplicatorAnnotationNotBefore         = "plumber-cd.github.io/argocd-cmp-replicator-not-before"
+	ReplicatorAnnotationNotAfter          = "plumber-cd.github.io/argocd-cmp-replicator-not-after"
+	ReplicatorAnnotationCertNotAfter      = "plumber-cd.github.io/argocd-cmp-replicator-cert-not-after"
+	ReplicatorAnnotationRotatedAt         = "plumber-cd.github.io/argocd-cmp-replicator-rotated-at"
+	ReplicatorAnnotationMaxAge            = "plumber-cd.github.io/argocd-cmp-replicator-max-age"
+	ReplicatorAnnotationStale             = "plumber-cd.github.io/argocd-cmp-replicator-stale"
+	ReplicatorAnnotationRetire            = "plumber-cd.github.io/argocd-cmp-replicator-retire"
+	ReplicatorAnnotationKeptSince         = "plumber-cd.github.io/argocd-cmp-replicator-kept-since"
+	ReplicatorAnnotationSyncWave          = "plumber-cd.github.io/argocd-cmp-replicator-sync-wave"
+	ReplicatorAnnotationSyncOptions       = "plumber-cd.github.io/argocd-cmp-replicator-sync-options"
+	ReplicatorAnnotationCompareOptions    = "plumber-cd.github.io/argocd-cmp-replicator-compare-options"
+	ReplicatorAnnotationConsumers         = "plumber-cd.github.io/argocd-cmp-replicator-consumers"
+	ReplicatorLabelConsumersOf            = "plumber-cd.github.io/argocd-cmp-replicator-consumers-of"
 )